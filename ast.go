@@ -0,0 +1,118 @@
+package strinterp
+
+import (
+	"bytes"
+)
+
+// This file contains the exported representation of a parsed format
+// string, for the benefit of tooling that needs to understand format
+// strings without interpolating them.
+
+// A Node is one element of a parsed format string. It is one of Literal,
+// PercentEscape, Comment, or *Directive.
+//
+// The String method of a Node returns the node as it would be written in
+// a format string, with all necessary escaping.
+type Node interface {
+	String() string
+	isNode()
+}
+
+// A Literal is a run of literal text from a format string, with any
+// escaping backslashes already removed.
+type Literal string
+
+// PercentEscape is the "%%;" directive, which yields a literal % without
+// consuming an arg. In other Syntaxes, it is the open delimiter used as a
+// directive, as in "{{ {{ }}", and yields the open delimiter.
+type PercentEscape struct{}
+
+// A Comment is a "%# ...;" directive, which yields nothing and consumes
+// no arg. It holds the text after the '#', with any escaping backslashes
+// already removed.
+type Comment string
+
+// A Directive is a %...; specification in a format string, or its
+// equivalent in other Syntaxes. The first Stage names the formatter or
+// encoder that receives the argument, and the remaining Stages name the
+// encoders it is piped through, in the order they were written.
+//
+// Index is the explicit 1-based argument index given in brackets, as in
+// "%[2]cdata;", or 0 if the directive simply uses the next argument.
+//
+// Name is the dotted path of a named argument, as in
+// "%@user.Name|cdata;", or "" if the directive uses a positional
+// argument. At most one of Index and Name is set.
+type Directive struct {
+	Index  int
+	Name   string
+	Stages []Stage
+}
+
+// A Stage is a single element of a Directive's pipeline.
+//
+// Params contains the parameters that followed the colon with all escaping
+// removed, exactly as the formatter or encoder will receive them. Params
+// is nil if no colon was used.
+type Stage struct {
+	Name   string
+	Params []byte
+}
+
+func (l Literal) isNode()     {}
+func (PercentEscape) isNode() {}
+func (c Comment) isNode()     {}
+func (d *Directive) isNode()  {}
+
+// String implements the Node interface. Like all the String methods here,
+// it uses the DefaultSyntax.
+func (p PercentEscape) String() string {
+	return nodeString(p)
+}
+
+// String implements the Node interface.
+func (l Literal) String() string {
+	return nodeString(l)
+}
+
+// String implements the Node interface.
+func (c Comment) String() string {
+	return nodeString(c)
+}
+
+// String implements the Node interface.
+func (d *Directive) String() string {
+	return nodeString(d)
+}
+
+// String returns the stage as it would be written in a format string.
+func (s Stage) String() string {
+	buf := new(bytes.Buffer)
+	DefaultSyntax.writeStage(buf, s)
+	return buf.String()
+}
+
+func nodeString(node Node) string {
+	buf := new(bytes.Buffer)
+	DefaultSyntax.writeNode(buf, node)
+	return buf.String()
+}
+
+// Parse parses the given format string into its component Nodes, without
+// resolving any of the formatters or encoders named by it. It uses the
+// DefaultSyntax; see Syntax.Parse for others.
+//
+// Literal text between two other Nodes is always returned as a single
+// Literal.
+//
+// A malformed format string is reported as a *ParseError.
+func Parse(format string) ([]Node, error) {
+	return DefaultSyntax.Parse(format)
+}
+
+// Format turns the given Nodes back into a format string using the
+// DefaultSyntax. For any nodes returned by Parse, Parse(Format(nodes))
+// yields the same nodes back.
+func Format(nodes []Node) string {
+	return DefaultSyntax.Format(nodes)
+}
@@ -0,0 +1,337 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		format string
+		nodes  []Node
+	}{
+		{"", []Node{}},
+		{"abc", []Node{Literal("abc")}},
+		{`a\%b\\c`, []Node{Literal(`a%b\c`)}},
+		{"x%%;y", []Node{Literal("x"), PercentEscape{}, Literal("y")}},
+		{"%RAW;", []Node{&Directive{Stages: []Stage{{"RAW", nil}}}}},
+		{"%p:;", []Node{&Directive{Stages: []Stage{{"p", []byte{}}}}}},
+		{"a%json|base64:url;b", []Node{
+			Literal("a"),
+			&Directive{Stages: []Stage{{"json", nil}, {"base64", []byte("url")}}},
+			Literal("b"),
+		}},
+		{`%p:a\;b\:c:d;`, []Node{&Directive{Stages: []Stage{{"p", []byte("a;b:c:d")}}}}},
+		{"%[2]RAW;%[10]json|base64;", []Node{
+			&Directive{Index: 2, Stages: []Stage{{"RAW", nil}}},
+			&Directive{Index: 10, Stages: []Stage{{"json", nil}, {"base64", nil}}},
+		}},
+		{"%@user.Name|cdata|base64:url;", []Node{&Directive{Name: "user.Name",
+			Stages: []Stage{{"cdata", nil}, {"base64", []byte("url")}}}}},
+		{`%\@a|@b;`, []Node{&Directive{Stages: []Stage{{"@a", nil}, {"@b", nil}}}}},
+		{`%\[2]RAW;%p:[2];`, []Node{
+			&Directive{Stages: []Stage{{"[2]RAW", nil}}},
+			&Directive{Stages: []Stage{{"p", []byte("[2]")}}},
+		}},
+	}
+
+	for _, test := range tests {
+		nodes, err := Parse(test.format)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("for %s, got unexpected error %v", test.format, err))
+		}
+		if !reflect.DeepEqual(nodes, test.nodes) {
+			t.Fatal(fmt.Sprintf("for %s, expected %#v, got %#v", test.format, test.nodes, nodes))
+		}
+	}
+
+	_, err := Parse("abc%RAW")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 3 || pe.Err != errIncompleteFormatString {
+		t.Fatal("Parse does not report incomplete format strings:", err)
+	}
+
+	for format, offset := range map[string]int{
+		"%[]RAW;": 2, "%[0]RAW;": 3, "%[x]RAW;": 2, "%[1x]RAW;": 3,
+		"%[1234567890]RAW;": 11, "%[-1]RAW;": 2,
+	} {
+		_, err = Parse(format)
+		if !errors.As(err, &pe) || pe.Offset != offset || pe.Err != errBadArgIndex {
+			t.Fatal(fmt.Sprintf("for %s, expected bad arg index at %d, got %v", format, offset, err))
+		}
+	}
+	for format, offset := range map[string]int{
+		"%@|RAW;": 1, "%@a.|RAW;": 1, "%@.a|RAW;": 1, "%@a..b|RAW;": 1,
+		"%@a\\|b|RAW;": 1,
+	} {
+		_, err = Parse(format)
+		if !errors.As(err, &pe) || pe.Offset != offset || pe.Err != errBadArgName {
+			t.Fatal(fmt.Sprintf("for %s, expected bad arg name at %d, got %v", format, offset, err))
+		}
+	}
+	_, err = Parse("%@a;")
+	if !errors.As(err, &pe) || pe.Err != errNoPipeline {
+		t.Fatal("Parse does not require a pipeline after named arguments:", err)
+	}
+	_, err = Parse("%@abc")
+	if !errors.As(err, &pe) || pe.Err != errIncompleteFormatString {
+		t.Fatal("Parse does not report incomplete arg names:", err)
+	}
+
+	_, err = Parse("%[12")
+	if !errors.As(err, &pe) || pe.Offset != 0 || pe.Err != errIncompleteFormatString {
+		t.Fatal("Parse does not report incomplete arg indexes:", err)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		nodes  []Node
+		format string
+	}{
+		{[]Node{Literal(`50% \o/`)}, `50\% \\o/`},
+		{[]Node{Literal("x"), PercentEscape{}, Literal("y")}, "x%%;y"},
+		{[]Node{&Directive{Stages: []Stage{{"RAW", nil}}}}, "%RAW;"},
+		{[]Node{&Directive{Stages: []Stage{{"p", []byte{}}}}}, "%p:;"},
+		{[]Node{&Directive{Stages: []Stage{{"p", []byte("c;d:e")}}}}, `%p:c\;d:e;`},
+		{[]Node{&Directive{Stages: []Stage{{"a:b", []byte("c|d:e")}}}}, `%a\:b:c\|d:e;`},
+		{[]Node{&Directive{Stages: []Stage{{"p", []byte(`\`)}, {"RAW", nil}}}}, `%p:\\|RAW;`},
+		{[]Node{&Directive{Stages: []Stage{{"json", nil}, {"base64", []byte("url")}}}},
+			"%json|base64:url;"},
+		{[]Node{&Directive{Index: 3, Stages: []Stage{{"[p]", []byte("[3]")}}}},
+			`%[3]\[p]:[3];`},
+		{[]Node{&Directive{Name: "a.b", Stages: []Stage{{"@p", []byte("@")}}}},
+			`%@a.b|\@p:@;`},
+	}
+
+	for _, test := range tests {
+		format := Format(test.nodes)
+		if format != test.format {
+			t.Fatal(fmt.Sprintf("for %#v, expected %s, got %s", test.nodes, test.format, format))
+		}
+		nodes, err := Parse(format)
+		if err != nil || !reflect.DeepEqual(nodes, test.nodes) {
+			t.Fatal(fmt.Sprintf("for %s, round trip yielded %#v, %v", format, nodes, err))
+		}
+	}
+}
+
+// This covers every escape the package documentation promises, at every
+// level they can appear.
+func TestDirectiveEscaping(t *testing.T) {
+	tests := []struct {
+		format string
+		stages []Stage
+	}{
+		// in the parameters of the first stage
+		{`%p:a\|b;`, []Stage{{"p", []byte("a|b")}}},
+		{`%p:a\:b;`, []Stage{{"p", []byte("a:b")}}},
+		{`%p:a:b;`, []Stage{{"p", []byte("a:b")}}},
+		{`%p:a\;b;`, []Stage{{"p", []byte("a;b")}}},
+		{`%p:a\\b;`, []Stage{{"p", []byte(`a\b`)}}},
+		{`%p:\|\:\;\\;`, []Stage{{"p", []byte(`|:;\`)}}},
+
+		// an escaped backslash does not escape what follows it
+		{`%p:a\\|RAW;`, []Stage{{"p", []byte(`a\`)}, {"RAW", nil}}},
+		{`%p:a\\:b;`, []Stage{{"p", []byte(`a\:b`)}}},
+		{`%p:a\\;`, []Stage{{"p", []byte(`a\`)}}},
+		{`%p:a\\\|b;`, []Stage{{"p", []byte(`a\|b`)}}},
+
+		// backslashes in front of anything else are passed through for
+		// the parameters to interpret
+		{`%p:\d+\,x;`, []Stage{{"p", []byte(`\d+\,x`)}}},
+		{`%p:\%;`, []Stage{{"p", []byte(`\%`)}}},
+
+		// in the parameters of later stages
+		{`%RAW|p:a\|b\:c;`, []Stage{{"RAW", nil}, {"p", []byte("a|b:c")}}},
+		{`%RAW|p:a\;b|RAW;`, []Stage{{"RAW", nil}, {"p", []byte("a;b")}, {"RAW", nil}}},
+		{`%RAW|p:\\|p:\|;`, []Stage{{"RAW", nil}, {"p", []byte(`\`)}, {"p", []byte("|")}}},
+
+		// in names
+		{`%a\:b:c;`, []Stage{{"a:b", []byte("c")}}},
+		{`%a\|b|c\;d;`, []Stage{{"a|b", nil}, {"c;d", nil}}},
+		{`%\%;`, []Stage{{`\%`, nil}}},
+
+		// empty things
+		{`%;`, []Stage{{"", nil}}},
+		{`%p:|:;`, []Stage{{"p", []byte{}}, {"", []byte{}}}},
+	}
+
+	for _, test := range tests {
+		nodes, err := Parse(test.format)
+		if err != nil || len(nodes) != 1 {
+			t.Fatal(fmt.Sprintf("for %s, got %#v, %v", test.format, nodes, err))
+		}
+		d, isDirective := nodes[0].(*Directive)
+		if !isDirective || !reflect.DeepEqual(d.Stages, test.stages) {
+			t.Fatal(fmt.Sprintf("for %s, expected %#v, got %#v", test.format, test.stages, nodes[0]))
+		}
+
+		// and the round trip must be lossless
+		again, err := Parse(Format(nodes))
+		if err != nil || !reflect.DeepEqual(again, nodes) {
+			t.Fatal(fmt.Sprintf("for %s, round trip through %s yielded %#v", test.format, Format(nodes), again))
+		}
+	}
+
+	for _, format := range []string{`%p:a\`, `%p:a\;`, `%RAW|p\|`} {
+		_, err := Parse(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Err != errIncompleteFormatString || pe.Directive != format {
+			t.Fatal(fmt.Sprintf("for %s, expected incomplete format string, got %v", format, err))
+		}
+	}
+}
+
+// Encoders must be able to receive the delimiters in their parameters.
+func TestEscapedParameters(t *testing.T) {
+	i := NewInterpolator()
+	i.AddEncoder("sep", func(w io.Writer, params []byte) (io.Writer, error) {
+		return WriterFunc(func(b []byte) (int, error) {
+			for idx := range b {
+				if idx > 0 {
+					w.Write(params)
+				}
+				w.Write(b[idx : idx+1])
+			}
+			return len(b), nil
+		}), nil
+	})
+
+	tests := []struct {
+		format string
+		result string
+	}{
+		{`%sep:\|;`, "a|b|c"},
+		{`%sep:\:;`, "a:b:c"},
+		{`%sep:\;;`, "a;b;c"},
+		{`%sep:\\;`, `a\b\c`},
+		{`%RAW|sep:\|\:|sep:\\;`, `a|\:b|\:c`},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, "abc")
+		if err != nil || res != test.result {
+			t.Fatal(fmt.Sprintf("for %s, expected %s, got %s (%v)", test.format, test.result, res, err))
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		format string
+		nodes  []Node
+	}{
+		{"a%# note;b", []Node{Literal("a"), Comment(" note"), Literal("b")}},
+		{"%#;", []Node{Comment("")}},
+		{`%#a\;b|c:d\\e\x;`, []Node{Comment(`a;b|c:d\ex`)}},
+		{"%#RAW;%RAW;", []Node{Comment("RAW"), &Directive{Stages: []Stage{{"RAW", nil}}}}},
+		{`%\#a;%a#b;`, []Node{
+			&Directive{Stages: []Stage{{"#a", nil}}},
+			&Directive{Stages: []Stage{{"a#b", nil}}},
+		}},
+	}
+
+	for _, test := range tests {
+		nodes, err := Parse(test.format)
+		if err != nil || !reflect.DeepEqual(nodes, test.nodes) {
+			t.Fatal(fmt.Sprintf("for %s, expected %#v, got %#v, %v", test.format, test.nodes, nodes, err))
+		}
+		again, err := Parse(Format(nodes))
+		if err != nil || !reflect.DeepEqual(again, nodes) {
+			t.Fatal(fmt.Sprintf("for %s, round trip through %s yielded %#v", test.format, Format(nodes), again))
+		}
+	}
+
+	i := NewDefaultInterpolator()
+	for _, format := range []string{"<%# the name;%cdata;>", "<%#%RAW;%cdata;>"} {
+		res, err := i.InterpStr(format, "x")
+		if err != nil || res != "<x>" {
+			t.Fatal(fmt.Sprintf("for %s, comments are not ignored: %q, %v", format, res, err))
+		}
+		res, err = i.MustCompile(format).String("x")
+		if err != nil || res != "<x>" {
+			t.Fatal(fmt.Sprintf("for %s, compiled comments are not ignored: %q, %v", format, res, err))
+		}
+	}
+
+	_, err := Parse("abc%# note")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 3 || pe.Err != errIncompleteFormatString {
+		t.Fatal("Parse does not report incomplete comments:", err)
+	}
+}
+
+func TestTrimMarkers(t *testing.T) {
+	i := NewDefaultInterpolator()
+	err := i.AddEncoder("-", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		nodes  []Node
+	}{
+		{"a \n\t%- RAW;", []Node{Literal("a"), &Directive{Stages: []Stage{{"RAW", nil}}}}},
+		{"%RAW -;\n  b", []Node{&Directive{Stages: []Stage{{"RAW", nil}}}, Literal("b")}},
+		{"a\n%-  RAW|-  -;\nb", []Node{
+			Literal("a"), &Directive{Stages: []Stage{{"RAW", nil}, {"-", nil}}}, Literal("b"),
+		}},
+		{"a\n%- # note -;\nb", []Node{Literal("a"), Comment(" note"), Literal("b")}},
+		{"a\n%# note -;\n%- RAW;", []Node{Literal("a\n"), Comment(" note"),
+			&Directive{Stages: []Stage{{"RAW", nil}}}}},
+
+		// escaped whitespace is not trimmed
+		{"a\\ %- RAW -;\\\nb", []Node{Literal("a "), &Directive{Stages: []Stage{{"RAW", nil}}},
+			Literal("\nb")}},
+
+		// without the whitespace, there is no marker
+		{"a %-RAW;", []Node{Literal("a "), &Directive{Stages: []Stage{{"-RAW", nil}}}}},
+		{"%RAW|-; b", []Node{&Directive{Stages: []Stage{{"RAW", nil}, {"-", nil}}}, Literal(" b")}},
+		{"%p:a -; b", []Node{&Directive{Stages: []Stage{{"p", []byte("a")}}}, Literal("b")}},
+		{"%p:a-; b", []Node{&Directive{Stages: []Stage{{"p", []byte("a-")}}}, Literal(" b")}},
+
+		// and escaped dashes are not markers either
+		{`a %\- RAW;`, []Node{Literal("a "), &Directive{Stages: []Stage{{"- RAW", nil}}}}},
+		{`%p:a \-; b`, []Node{&Directive{Stages: []Stage{{"p", []byte("a -")}}}, Literal(" b")}},
+		{`%# a \-; b`, []Node{Comment(" a -"), Literal(" b")}},
+		{`%p:a\-b;`, []Node{&Directive{Stages: []Stage{{"p", []byte(`a\-b`)}}}}},
+	}
+
+	for _, test := range tests {
+		nodes, err := Parse(test.format)
+		if err != nil || !reflect.DeepEqual(nodes, test.nodes) {
+			t.Fatal(fmt.Sprintf("for %q, expected %#v, got %#v, %v", test.format, test.nodes, nodes, err))
+		}
+		again, err := Parse(Format(nodes))
+		if err != nil || !reflect.DeepEqual(again, nodes) {
+			t.Fatal(fmt.Sprintf("for %q, round trip through %q yielded %#v", test.format, Format(nodes), again))
+		}
+	}
+
+	res, err := i.InterpStr("<ul>\n  %- RAW -;\n</ul>", "x")
+	if err != nil || res != "<ul>x</ul>" {
+		t.Fatal("trim markers do not trim:", res, err)
+	}
+
+	// Markers take effect on literal text however it is chunked when
+	// streaming.
+	spaces := strings.Repeat(" ", maxLiteralChunk)
+	text := strings.Repeat("x", maxLiteralChunk-5)
+	for format, expected := range map[string]string{
+		text + spaces + "%- RAW -;" + spaces + text: text + "!" + text,
+		spaces + spaces + "%- RAW;" + text:          "!" + text,
+		text + spaces + "%RAW;":                     text + spaces + "!",
+	} {
+		rw := &recordingWriter{}
+		err = i.InterpReader(rw, strings.NewReader(format), "!")
+		if err != nil || rw.String() != expected || rw.largest > maxLiteralChunk {
+			t.Fatal(fmt.Sprintf("streaming trims wrongly: %d bytes, %v", rw.Len(), err))
+		}
+	}
+}
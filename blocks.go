@@ -0,0 +1,190 @@
+package strinterp
+
+import (
+	"io"
+	"reflect"
+)
+
+// This file contains the directives that span a block of the format
+// string, rather than interpolating an arg where they stand.
+//
+// Blocks are executed strictly in order, so that a streamed format string
+// never needs to be looked ahead in. The parts of the format string that
+// a false %if; rules out are still read, but skipped.
+
+// A blockKind identifies the directives that open and close blocks.
+type blockKind int
+
+const (
+	noBlock blockKind = iota
+	// %begin|...; opens a region, where everything up to the matching
+	// %end; is written through the begin's encoders
+	beginBlock
+	// %if; opens a conditional section, which is skipped up to the
+	// matching %else; or %end; if its arg is false
+	ifBlock
+	elseBlock
+	endBlock
+)
+
+var blockKinds = map[string]blockKind{
+	"begin": beginBlock,
+	"if":    ifBlock,
+	"else":  elseBlock,
+	"end":   endBlock,
+}
+
+var ifSpec = ParamSpec{
+	{Name: "given", Type: BoolParam},
+}
+
+// compileBlock resolves a directive that opens or closes a block.
+func (i *Interpolator) compileBlock(n *Directive) (*directive, int, error) {
+	first := n.Stages[0]
+	d := &directive{block: blockKinds[first.Name]}
+
+	spec := ParamSpec{}
+	if d.block == ifBlock {
+		spec = ifSpec
+	}
+	params, err := spec.Parse(first.Params)
+	if err != nil {
+		return nil, 0, err
+	}
+	d.ifGiven = params.Bool("given")
+
+	if d.block != ifBlock && (n.Index > 0 || n.Name != "") {
+		return nil, 0, errBlockArg
+	}
+
+	if d.block != beginBlock {
+		if len(n.Stages) > 1 {
+			return nil, 1, errBlockPipeline
+		}
+		return d, 0, nil
+	}
+
+	if len(n.Stages) == 1 {
+		return nil, 0, errBeginNoPipeline
+	}
+	pipeline, at, err := i.compilePipeline(n.Stages[1:], 1)
+	if err != nil {
+		return nil, at, err
+	}
+	d.pipeline = pipeline
+	return d, 0, nil
+}
+
+// block keeps track of the blocks that are open as the directives that
+// open and close them are compiled.
+func (c *compiler) block(d *directive) error {
+	switch d.block {
+	case beginBlock, ifBlock:
+		c.blocks = append(c.blocks, d)
+	case elseBlock:
+		last := len(c.blocks) - 1
+		if last < 0 || c.blocks[last].block != ifBlock {
+			return d.parseError(errElseWithoutIf)
+		}
+		c.blocks[last] = d
+	case endBlock:
+		last := len(c.blocks) - 1
+		if last < 0 {
+			return d.parseError(errUnmatchedEnd)
+		}
+		d.closes = c.blocks[last].block
+		c.blocks = c.blocks[:last]
+	}
+	return nil
+}
+
+// finish checks that every block has been closed once the end of the
+// format string has been reached.
+func (c *compiler) finish() error {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	d := c.blocks[len(c.blocks)-1]
+	if d.block == beginBlock {
+		return d.parseError(errUnclosedBegin)
+	}
+	return d.parseError(errUnclosedIf)
+}
+
+// parseError reports a problem with the directive as a whole.
+func (d *directive) parseError(err error) error {
+	return &ParseError{d.offset, d.index, d.raw, caretSnippet(d.raw, 0), err}
+}
+
+// block executes a directive that opens or closes a block, other than
+// %if;, which needs its arg.
+func (e *execution) block(d *directive) error {
+	switch d.block {
+	case beginBlock:
+		writer, err := newPipelineWriter(e.w, d.pipeline)
+		if err != nil {
+			return err
+		}
+		e.regions = append(e.regions, region{writer, e.w})
+		e.w = writer
+	case elseBlock:
+		// the if was true, so the else section is skipped
+		e.skip = 1
+	case endBlock:
+		if d.closes != beginBlock {
+			return nil
+		}
+		r := e.regions[len(e.regions)-1]
+		e.regions = e.regions[:len(e.regions)-1]
+		e.w = r.outer
+		return r.writer.Finish()
+	}
+	return nil
+}
+
+// skipBlock tracks the blocks within a section that is being skipped, so
+// that the section ends at the right place.
+func (e *execution) skipBlock(d *directive) {
+	switch d.block {
+	case beginBlock, ifBlock:
+		e.skip++
+	case elseBlock:
+		if e.skip == 1 {
+			// the if was false, so the else section is executed
+			e.skip = 0
+		}
+	case endBlock:
+		e.skip--
+	}
+}
+
+// A region is a begin block being executed, and the writer that was in
+// use before it began.
+type region struct {
+	writer *WriterStack
+	outer  io.Writer
+}
+
+// test determines whether the section after an %if; is executed. With the
+// given parameter, the arg only needs to have been given, and not be nil.
+// Otherwise, it also must not be zero or empty.
+func (d *directive) test(arg interface{}) bool {
+	if _, isNotGiven := arg.(NotGivenType); isNotGiven || arg == nil {
+		return false
+	}
+	v := reflect.ValueOf(arg)
+	if d.ifGiven {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice,
+			reflect.Func, reflect.Chan:
+			return !v.IsNil()
+		}
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array, reflect.Chan:
+		return v.Len() > 0
+	}
+	return !v.IsZero()
+}
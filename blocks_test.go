@@ -0,0 +1,192 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRegions(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []interface{}
+		result string
+	}{
+		{"a%begin|base64;b%RAW;c%end;d", []interface{}{"x"}, "a" + "Ynhj" + "d"},
+		{"%begin|cdata;<%RAW;>%end;", []interface{}{"<"}, "&lt;&lt;&gt;"},
+		// the region's encoders come after the directive's own
+		{"%begin|base64;%RAW|base64;%end;", []interface{}{"a"}, "WVE9PQ=="},
+		{"%begin|base64|cdata;a%end;", []interface{}{}, "YQ=="},
+		// nesting
+		{"%begin|base64;a%begin|base64;b%end;c%end;", []interface{}{}, "YVlnPT1j"},
+		{"%begin|cdata;<%end;<%begin|cdata;>%end;", []interface{}{}, "&lt;<&gt;"},
+		{"%begin|base64;%end;", []interface{}{}, ""},
+		{"%begin|cdata;%each:sep=<|RAW;%end;", []interface{}{[]string{"a", "b"}}, "a&lt;b"},
+	}
+
+	i := NewDefaultInterpolator()
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.args...)
+		if err != nil || res != test.result {
+			t.Fatal(fmt.Sprintf("for %s, expected %q, got %q (%v)", test.format, test.result, res, err))
+		}
+
+		buf := &recordingWriter{}
+		err = i.InterpReader(buf, strings.NewReader(test.format), test.args...)
+		if err != nil || buf.String() != test.result {
+			t.Fatal(fmt.Sprintf("for %s, streaming got %q (%v)", test.format, buf.String(), err))
+		}
+	}
+
+	// regions do not consume args
+	res, err := i.Strict().InterpStr("%begin|cdata;%RAW;%end;%RAW;", "<", ">")
+	if err != nil || res != "&lt;>" {
+		t.Fatal("regions consume args:", res, err)
+	}
+
+	for format, test := range map[string]struct {
+		offset int
+		cause  error
+	}{
+		"ab%end;":                           {2, errUnmatchedEnd},
+		"%begin|cdata;%end;%end;":           {18, errUnmatchedEnd},
+		"ab%begin|cdata;":                   {2, errUnclosedBegin},
+		"%begin|cdata;%begin|RAW;%end;":     {0, errUnclosedBegin},
+		"%begin|cdata;x%begin|RAW;":         {14, errUnclosedBegin},
+		"ab%begin;%end;":                    {3, errBeginNoPipeline},
+		"ab%begin|json;%end;":               {9, errUnknownEncoder("json")},
+		"ab%end|cdata;":                     {7, errBlockPipeline},
+		"ab%[1]begin|cdata;%end;":           {6, errBlockArg},
+		"ab%@x|begin|cdata;%end;":           {6, errBlockArg},
+		"ab%begin:x|cdata;%end;":            {3, ErrUnknownArguments{[]byte("x"), `unknown parameter "x"; no parameters are allowed`}},
+		"ab%begin|cdata|base64:bad;%end;":   {15, nil},
+		"%begin|cdata;ab%end:nope;%begin;x": {16, ErrUnknownArguments{[]byte("nope"), `unknown parameter "nope"; no parameters are allowed`}},
+	} {
+		_, err := i.Compile(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Offset != test.offset ||
+			(test.cause != nil && fmt.Sprint(pe.Err) != fmt.Sprint(test.cause)) {
+			t.Fatal(fmt.Sprintf("for %s, expected %v at %d, got %v", format, test.cause, test.offset, err))
+		}
+	}
+
+	if i.AddEncoder("begin", raw) != errAlreadyExists("begin") ||
+		i.AddFormatter("end", JSON) != errAlreadyExists("end") {
+		t.Fatal("begin and end can be registered")
+	}
+
+	// failing to finish a region is reported against the end
+	i.AddEncoder("badclose", badCloseEncoder)
+	_, err = i.InterpStr("ab%begin|badclose;x%end;")
+	var de *DirectiveError
+	if !errors.As(err, &de) || de.Offset != 19 || de.Err != ErrBadClose {
+		t.Fatal("region failures are not reported:", err)
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	var nilPtr *int
+	one := 1
+
+	tests := []struct {
+		format string
+		args   []interface{}
+		result string
+	}{
+		{"a%if;b%end;c", []interface{}{true}, "abc"},
+		{"a%if;b%end;c", []interface{}{false}, "ac"},
+		{"a%if;b%else;c%end;d", []interface{}{true}, "abd"},
+		{"a%if;b%else;c%end;d", []interface{}{false}, "acd"},
+		{"%if;%RAW; and %RAW; others%end;", []interface{}{1, "2", "3"}, "2 and 3 others"},
+		{"%if;%RAW; and %RAW; others%end;", []interface{}{0, "2", "3"}, ""},
+		{"%[2]if;and %[2]RAW; others%end;", []interface{}{"x", "3"}, "and 3 others"},
+
+		// what counts as true
+		{"%if;y%else;n%end;", []interface{}{""}, "n"},
+		{"%if;y%else;n%end;", []interface{}{"x"}, "y"},
+		{"%if;y%else;n%end;", []interface{}{[]int{}}, "n"},
+		{"%if;y%else;n%end;", []interface{}{[]int{0}}, "y"},
+		{"%if;y%else;n%end;", []interface{}{map[string]int{}}, "n"},
+		{"%if;y%else;n%end;", []interface{}{0.0}, "n"},
+		{"%if;y%else;n%end;", []interface{}{uint8(3)}, "y"},
+		{"%if;y%else;n%end;", []interface{}{nil}, "n"},
+		{"%if;y%else;n%end;", []interface{}{nilPtr}, "n"},
+		{"%if;y%else;n%end;", []interface{}{&one}, "y"},
+		{"%if;y%else;n%end;", []interface{}{struct{}{}}, "n"},
+		{"%if;y%else;n%end;", []interface{}{NotGiven}, "n"},
+		{"%if;y%else;n%end;", []interface{}{}, "n"},
+		{"%if:given;y%else;n%end;", []interface{}{""}, "y"},
+		{"%if:given;y%else;n%end;", []interface{}{0}, "y"},
+		{"%if:given;y%else;n%end;", []interface{}{[]int(nil)}, "n"},
+		{"%if:given;y%else;n%end;", []interface{}{nil}, "n"},
+		{"%if:given;y%else;n%end;", []interface{}{}, "n"},
+
+		// nesting, with everything within a skipped section skipped
+		{"%if;a%if;b%else;c%end;d%else;e%if;f%end;g%end;", []interface{}{true, false, true}, "acd"},
+		{"%if;a%if;b%else;c%end;d%else;e%if;f%end;g%end;", []interface{}{false, false, true}, "efg"},
+		{"%if;a%if;b%else;c%end;d%else;e%if;f%end;g%end;", []interface{}{false, true, false}, "eg"},
+		{"%if;%begin|base64;a%end;%else;%begin|cdata;<%end;%end;", []interface{}{true}, "YQ=="},
+		{"%if;%begin|base64;a%end;%else;%begin|cdata;<%end;%end;", []interface{}{false}, "&lt;"},
+		{"%begin|cdata;%if;<%else;>%end;%end;", []interface{}{true}, "&lt;"},
+		// skipped directives are not run at all
+		{"%if;%RAW;%end;", []interface{}{false, 17}, ""},
+		{"%if;%each|RAW;%end;", []interface{}{false, "not iterable"}, ""},
+	}
+
+	i := NewDefaultInterpolator()
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.args...)
+		if err != nil || res != test.result {
+			t.Fatal(fmt.Sprintf("for %s with %v, expected %q, got %q (%v)", test.format, test.args, test.result, res, err))
+		}
+
+		buf := &recordingWriter{}
+		err = i.InterpReader(buf, strings.NewReader(test.format), test.args...)
+		if err != nil || buf.String() != test.result {
+			t.Fatal(fmt.Sprintf("for %s with %v, streaming got %q (%v)", test.format, test.args, buf.String(), err))
+		}
+	}
+
+	res, err := i.InterpNamed("%@admin|if;admin%else;user%end;", map[string]interface{}{"admin": true})
+	if err != nil || res != "admin" {
+		t.Fatal("if does not work with named args:", res, err)
+	}
+	res, err = i.InterpNamed("%@admin|if;admin%else;user%end;", map[string]interface{}{})
+	if err != nil || res != "user" {
+		t.Fatal("if does not treat missing names as false:", res, err)
+	}
+
+	// if consumes its arg, in strict mode too
+	err = i.Strict().CheckArity("%if;%RAW;%else;%RAW;%end;", 3)
+	if err != nil {
+		t.Fatal("if does not consume an arg:", err)
+	}
+	_, err = i.Strict().InterpStr("%if;x%end;")
+	if !errors.As(err, new(ErrMissingArguments)) {
+		t.Fatal("if does not respect strict mode:", err)
+	}
+
+	for format, test := range map[string]struct {
+		offset int
+		cause  error
+	}{
+		"ab%else;":                 {2, errElseWithoutIf},
+		"%if;a%else;b%else;c%end;": {12, errElseWithoutIf},
+		"%begin|RAW;%else;%end;":   {11, errElseWithoutIf},
+		"ab%if;":                   {2, errUnclosedIf},
+		"ab%if;%else;":             {6, errUnclosedIf},
+		"%if;%if;%end;":            {0, errUnclosedIf},
+		"ab%if|RAW;%end;":          {6, errBlockPipeline},
+		"ab%if;%else|RAW;%end;":    {12, errBlockPipeline},
+		"ab%if:x;%end;":            {3, nil},
+		"ab%if;%[1]else;%end;":     {10, errBlockArg},
+	} {
+		_, err := i.Compile(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Offset != test.offset ||
+			(test.cause != nil && pe.Err != test.cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %v at %d, got %v", format, test.cause, test.offset, err))
+		}
+	}
+}
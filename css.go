@@ -0,0 +1,245 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file contains the CSS encoders.
+
+// CSSString defines an Encoder for the contents of a quoted CSS string,
+// as in "content: '%cssstring;'". As OWASP recommends, every character
+// below 256 except ASCII letters and digits is written as a CSS hex
+// escape, so that nothing can end the string, the declaration, or the
+// <style> element or attribute it is in. It takes no parameters.
+func CSSString(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, cssStringEncoder)
+}
+
+func cssStringEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return newCSSEscaper(inner, func(r rune, first bool) bool {
+		return r < 256 && !isASCIIAlnum(r)
+	}), nil
+}
+
+// CSSIdent defines an Encoder for a CSS identifier, such as a class name
+// or an animation name, as in ".%cssident; { ... }". Everything other
+// than ASCII letters, digits, "-", "_" and non-ASCII characters is
+// written as a CSS hex escape, as is a leading digit or "-", so that the
+// result is always a single identifier.
+//
+// An empty identifier is an error. It takes no parameters.
+func CSSIdent(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, cssIdentEncoder)
+}
+
+func cssIdentEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	escaper := newCSSEscaper(inner, func(r rune, first bool) bool {
+		if first && (r == '-' || (r >= '0' && r <= '9')) {
+			return true
+		}
+		return r < utf8.RuneSelf && !isASCIIAlnum(r) && r != '-' && r != '_'
+	})
+	escaper.required = true
+	return escaper, nil
+}
+
+// A cssEscaper writes CSS hex escapes for the runes escape returns true
+// for.
+//
+// A hex escape ends at the first character that is not a hex digit, and
+// swallows one whitespace character after it, so a space has to be
+// written after one that is followed by a hex digit or whitespace. Since
+// whatever follows the encoder's output might be either, a final escape
+// always gets a space.
+type cssEscaper struct {
+	io.Writer
+	inner  io.Writer
+	escape func(r rune, first bool) bool
+	// set once anything has been written
+	written bool
+	// set if the last thing written was an escape
+	afterEscape bool
+	// set if writing nothing at all is an error
+	required bool
+}
+
+func newCSSEscaper(inner io.Writer, escape func(rune, bool) bool) *cssEscaper {
+	ce := &cssEscaper{inner: inner, escape: escape}
+	ce.Writer = escapeRunes(inner, ce.replace)
+	return ce
+}
+
+func (ce *cssEscaper) replace(r rune) []byte {
+	first := !ce.written
+	ce.written = true
+	afterEscape := ce.afterEscape
+	ce.afterEscape = false
+
+	if r == 0 || r == utf8.RuneError {
+		r = utf8.RuneError
+	} else if !ce.escape(r, first) {
+		if afterEscape && (isHexDigit(r) || isCSSSpace(r)) {
+			return append([]byte{' '}, string(r)...)
+		}
+		return nil
+	}
+
+	ce.afterEscape = true
+	return []byte(`\` + strconv.FormatInt(int64(r), 16))
+}
+
+// Close ends a final escape.
+func (ce *cssEscaper) Close() error {
+	if !ce.written && ce.required {
+		return errEmptyCSSIdent
+	}
+	if !ce.afterEscape {
+		return nil
+	}
+	ce.afterEscape = false
+	_, err := ce.inner.Write([]byte{' '})
+	return err
+}
+
+func isASCIIAlnum(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func isHexDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func isCSSSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// maxCSSValue is the longest value the validating CSS encoders accept.
+const maxCSSValue = 64
+
+// The pieces of the CSS grammar the validating encoders accept. Each is
+// only used where CSS allows it, so that, for instance, an angle can't be
+// used as a length.
+const (
+	cssNumber     = `[+-]?([0-9]+(\.[0-9]+)?|\.[0-9]+)`
+	cssPercentage = cssNumber + `%`
+	cssAngle      = cssNumber + `(deg|grad|rad|turn)?`
+	// an rgb() channel, or the alpha of any color
+	cssChannel = `(` + cssNumber + `|` + cssPercentage + `)`
+)
+
+// cssColorFunction matches the hex colors, and the rgb(), rgba(), hsl()
+// and hsla() colors, in both their comma-separated and space-separated
+// forms.
+var cssColorFunction = regexp.MustCompile(`^(?i:` +
+	`#([0-9a-f]{3}|[0-9a-f]{4}|[0-9a-f]{6}|[0-9a-f]{8})` +
+	`|rgba?\(\s*` + cssChannel + `(\s*,\s*` + cssChannel + `){2}(\s*,\s*` + cssChannel + `)?\s*\)` +
+	`|rgba?\(\s*` + cssChannel + `(\s+` + cssChannel + `){2}(\s*/\s*` + cssChannel + `)?\s*\)` +
+	`|hsla?\(\s*` + cssAngle + `(\s*,\s*` + cssPercentage + `){2}(\s*,\s*` + cssChannel + `)?\s*\)` +
+	`|hsla?\(\s*` + cssAngle + `(\s+` + cssPercentage + `){2}(\s*/\s*` + cssChannel + `)?\s*\)` +
+	`)$`)
+
+// cssColorNames are the CSS named colors, along with the other color
+// keywords.
+var cssColorNames = wordSet(`transparent currentcolor
+	aliceblue antiquewhite aqua aquamarine azure beige bisque black
+	blanchedalmond blue blueviolet brown burlywood cadetblue chartreuse
+	chocolate coral cornflowerblue cornsilk crimson cyan darkblue
+	darkcyan darkgoldenrod darkgray darkgreen darkgrey darkkhaki
+	darkmagenta darkolivegreen darkorange darkorchid darkred darksalmon
+	darkseagreen darkslateblue darkslategray darkslategrey darkturquoise
+	darkviolet deeppink deepskyblue dimgray dimgrey dodgerblue firebrick
+	floralwhite forestgreen fuchsia gainsboro ghostwhite gold goldenrod
+	gray green greenyellow grey honeydew hotpink indianred indigo ivory
+	khaki lavender lavenderblush lawngreen lemonchiffon lightblue
+	lightcoral lightcyan lightgoldenrodyellow lightgray lightgreen
+	lightgrey lightpink lightsalmon lightseagreen lightskyblue
+	lightslategray lightslategrey lightsteelblue lightyellow lime
+	limegreen linen magenta maroon mediumaquamarine mediumblue
+	mediumorchid mediumpurple mediumseagreen mediumslateblue
+	mediumspringgreen mediumturquoise mediumvioletred midnightblue
+	mintcream mistyrose moccasin navajowhite navy oldlace olive
+	olivedrab orange orangered orchid palegoldenrod palegreen
+	paleturquoise palevioletred papayawhip peachpuff peru pink plum
+	powderblue purple rebeccapurple red rosybrown royalblue saddlebrown
+	salmon sandybrown seagreen seashell sienna silver skyblue slateblue
+	slategray slategrey snow springgreen steelblue tan teal thistle
+	tomato turquoise violet wheat white whitesmoke yellow
+	yellowgreen`)
+
+// wordSet returns the set of the space-separated words.
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+func isCSSColor(value []byte) bool {
+	return cssColorNames[strings.ToLower(string(value))] || cssColorFunction.Match(value)
+}
+
+var cssLength = regexp.MustCompile(`^(?i:` +
+	`[+-]?0*\.?0+` +
+	`|` + cssNumber + `(px|em|rem|ex|ch|vw|vh|vmin|vmax|cm|mm|q|in|pt|pc)` +
+	`|` + cssPercentage +
+	`)$`)
+
+// CSSColor defines an Encoder for a CSS color, as in "color: %csscolor;".
+// Rather than escaping its input, it checks that it is a hex color, such
+// as "#0a0" or "#00aa00cc", a named color, such as "red", "transparent"
+// or "currentcolor", or an rgb(), rgba(), hsl() or hsla() color with
+// plain numbers, percentages and angles where CSS allows them. Anything
+// else is rejected with an error before anything is written. It takes no
+// parameters.
+func CSSColor(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, cssColorEncoder)
+}
+
+func cssColorEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return &cssValidator{inner: inner, valid: isCSSColor, kind: "color"}, nil
+}
+
+// CSSLength defines an Encoder for a CSS length, as in "width:
+// %csslength;". Like CSSColor, it checks its input rather than escaping
+// it, accepting a number followed by one of the CSS length units or "%",
+// as in "1.5em" or "-3px", or a plain zero. It takes no parameters.
+func CSSLength(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, cssLengthEncoder)
+}
+
+func cssLengthEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return &cssValidator{inner: inner, valid: cssLength.Match, kind: "length"}, nil
+}
+
+// A cssValidator holds back the value until it is complete, and then
+// writes it if it is valid.
+type cssValidator struct {
+	inner io.Writer
+	valid func([]byte) bool
+	kind  string
+	held  []byte
+}
+
+func (cv *cssValidator) Write(b []byte) (int, error) {
+	if len(cv.held)+len(b) > maxCSSValue {
+		return 0, errInvalidCSS(cv.kind)
+	}
+	cv.held = append(cv.held, b...)
+	return len(b), nil
+}
+
+// Close checks and writes out the value.
+func (cv *cssValidator) Close() error {
+	value := bytes.TrimSpace(cv.held)
+	if !cv.valid(value) {
+		return errInvalidCSS(cv.kind)
+	}
+	_, err := cv.inner.Write(value)
+	return err
+}
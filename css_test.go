@@ -0,0 +1,104 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCSSEscapers(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		format   string
+		arg      string
+		expected string
+	}{
+		{"'%cssstring;'", "", "''"},
+		{"'%cssstring;'", "abcXYZ019", "'abcXYZ019'"},
+		{"'%cssstring;'", "a b", `'a\20 b'`},
+		{"'%cssstring;'", "a'b", `'a\27 b'`},
+		{"'%cssstring;'", `"`, `'\22 '`},
+		{"'%cssstring;'", "日é", `'日\e9 '`},
+		{"'%cssstring;'", "</style>", `'\3c\2fstyle\3e '`},
+		{"'%cssstring;'", `\`, `'\5c '`},
+		{"'%cssstring;'", "\n;}", `'\a\3b\7d '`},
+		{"'%cssstring;'", "\x00\xff", `'\fffd\fffd '`},
+
+		// a space is only needed when a hex digit or whitespace follows,
+		// or at the end
+		{"'%cssstring;'", ".z", `'\2ez'`},
+		{"'%cssstring;'", ".a", `'\2e a'`},
+		{"'%cssstring;'", ".1", `'\2e 1'`},
+		{"'%cssstring;'", "..", `'\2e\2e '`},
+
+		{".%cssident;{}", "abc_d-e", ".abc_d-e{}"},
+		{".%cssident;{}", "日本", ".日本{}"},
+		{".%cssident;{}", "a b.c", `.a\20 b\2e c{}`},
+		{".%cssident;{}", "1a", `.\31 a{}`},
+		{".%cssident;{}", "-1", `.\2d 1{}`},
+		{".%cssident;{}", "--x", `.\2d-x{}`},
+		{".%cssident;{}", "a{}b", `.a\7b\7d b{}`},
+		{".%cssident;f{}", "a.", `.a\2e f{}`},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %s, got %s, %v",
+				test.format, test.arg, test.expected, res, err))
+		}
+	}
+
+	// the escapes are ended correctly however the output is written
+	buf := &recordingWriter{}
+	enc, _ := CSSString(buf, nil)
+	for _, chunk := range []string{"a ", "b", "'", "c"} {
+		_, _ = enc.Write([]byte(chunk))
+	}
+	_ = enc.(*cssEscaper).Close()
+	if buf.String() != `a\20 b\27 c` {
+		t.Fatal("cssstring does not end escapes across writes:", buf.String())
+	}
+
+	_, err := i.InterpStr(".%cssident;", "")
+	if !errors.Is(err, errEmptyCSSIdent) {
+		t.Fatal("empty identifiers are allowed:", err)
+	}
+}
+
+func TestCSSValidators(t *testing.T) {
+	i := NewDefaultInterpolator()
+	for encoder, values := range map[string][]string{
+		"csscolor": {"#fff", "#FFFF", "#00aa00", "#00aa00cc", "red", "Transparent",
+			"rgb(0, 128, 255)", "rgba(0,128,255,0.5)", "rgb(0 128 255 / 50%)",
+			"hsl(120deg, 100%, 50%)", " #abc ", "currentColor", "rebeccapurple",
+			"rgb(100%, 0%, 0%)", "hsl(120 100% 50% / 0.5)", "hsla(0.5turn, 10%, 20%, 50%)"},
+		"csslength": {"0", "0.0", "1px", "-3px", "+1.5em", ".5rem", "100%", "10VH"},
+	} {
+		for _, value := range values {
+			res, err := i.InterpStr("x:%"+encoder+";", value)
+			if err != nil || res != "x:"+strings.TrimSpace(value) {
+				t.Fatal(fmt.Sprintf("for %s, %q was rejected: %v", encoder, value, err))
+			}
+		}
+	}
+
+	for encoder, values := range map[string][]string{
+		"csscolor": {"", "#ff", "#fffff", "#ggg", "red;", "url(x)", "expression(alert(1))",
+			"rgb(0,0,0);x:y", "rgb(0 0)", "rgb(a,b,c)", "red blue", "re\\64",
+			strings.Repeat("a", 100), "notacolor", "expression", "rgb(1deg 2deg 3deg)",
+			"hsl(10%, 20%, 30%, 40%)", "hsl(10 20 30)", "rgb(0, 0 0)", "rgb(0 0 0 0)",
+			"rgb(1%%, 0, 0)"},
+		"csslength": {"", "1", "px", "1 px", "1px;", "1px 2px", "calc(1px)", "1e3px",
+			"expression(1)", "-0x1px", "1degpx", "5%em", "10%%", "1deg", "1px%"},
+	} {
+		for _, value := range values {
+			w := &recordingWriter{}
+			err := i.InterpWriter(w, []byte("%"+encoder+";"), value)
+			if !errors.Is(err, errInvalidCSS(strings.TrimPrefix(encoder, "css"))) || w.Len() != 0 {
+				t.Fatal(fmt.Sprintf("for %s, %q was accepted: %q, %v", encoder, value, w.String(), err))
+			}
+		}
+	}
+}
@@ -46,7 +46,9 @@ The "format string", the first element of the call, has the following
 syntax:
 
     * Begins with %, ends with unescaped ;
-    * Begins with the formatter/encoder name
+    * Optionally followed by an explicit argument index, like [2], or
+      an @ and an argument name followed by a pipe, like @user.Name|
+    * Then the formatter/encoder name
     * Which may be followed by a colon, then args for that formatter
     * Which may then be followed by a pipe, and further specifications
       of encoders with optional arguments
@@ -54,7 +56,11 @@ syntax:
 You may backslash-escape any of the pipe, colon, or semicolon to pass them
 through as arguments to the formatter/encoder, or backslash itself to pass
 it through. (The formatter/encoder will of course receive the decoded
-bytes without the escaping backslash.) To emit a raw %, use "%%;".
+bytes without the escaping backslash.) A backslash in front of anything
+else is passed through to the formatter/encoder as-is, so parameters
+that have their own backslash escaping, like regular expressions, do not
+need their backslashes doubled. To emit a raw %, use "%%;", or
+backslash-escape it outside of a directive.
 
 Here is an example of a format string that uses all these features:
 
@@ -65,6 +71,107 @@ obj, then it will be converted to base64, which will use the encoding/base64
 URLEncoding due to the "url" argument being passed. You can continue
 piping to further encoders indefinitely.
 
+Directives consume their args from left to right, unless they are given
+an explicit 1-based argument index, which works like the ones in fmt:
+
+    i.InterpStr(`<a title="%[1]cdata;">%[1]cdata;</a> %[3]RAW; %RAW;`,
+        title, unused, three, four)
+
+Directives without an index carry on from the last explicit index used.
+An explicit index with no corresponding arg is an error, reported before
+anything is written when using InterpWriter or a Template.
+
+By default, like fmt, args that are not used are ignored, and directives
+with no corresponding arg receive NotGiven. In strict mode, selected with
+SetStrict for a whole Interpolator or with Strict for a single call, such
+mismatches are errors, reported before anything is written:
+
+    result, err := i.Strict().InterpStr("%RAW;", a, b) // ErrExtraArguments
+
+CheckArity performs the same check for a given number of args without
+interpolating anything.
+
+For large format strings, positional args become hard to keep track of.
+Directives can instead name their argument with a dotted path, which is
+resolved against a single map or struct with InterpNamed or
+Template.ExecuteNamed:
+
+    i.InterpNamed("<b>%@user.Name|cdata;</b>", map[string]interface{}{
+        "user": user,
+    })
+
+The path descends through maps with string keys, exported struct fields,
+and pointers; struct fields can be renamed with a "strinterp" struct tag.
+Names that can not be resolved are passed to the formatter/encoder as
+NotGiven.
+
+To render a list, start the pipeline with "each". The rest of the
+pipeline is then applied to every element of the arg in turn, each with
+encoders of its own, and the "sep" parameter, if any, is written as-is
+between the elements:
+
+    i.InterpStr(`<p>Tags: %each:sep=\, |cdata;</p>`, tags)
+
+The arg may be a slice, an array, a channel, which is read until it is
+closed, or an iterator function such as an iter.Seq.
+
+To run a whole span of the format string through encoders, including the
+literal text and the output of the directives within it, put it between
+"%begin|...;" and "%end;":
+
+    i.InterpStr("data:text/html;base64,%begin|base64;<b>%cdata;</b>%end;", name)
+
+The encoders are finished at the %end;, so any output they buffer is
+flushed there. Regions may be nested, and do not consume args. A %begin;
+without a matching %end;, or vice versa, is a *ParseError.
+
+Optional parts of a format string can be put in a conditional section,
+which tests the next arg:
+
+    i.InterpStr("%cdata;%if; and %RAW; others%end;", name, n, strconv.Itoa(n))
+
+The section up to the %end; is only written if the arg is given, not
+nil, and not zero or empty; an optional %else; introduces a section to
+write otherwise. With "%if:given;", the arg only needs to be given and
+not nil. There is deliberately no expression language; compute anything
+more complicated in Go and pass in the result. Like other directives,
+%if; can use an explicit index or a named argument.
+
+Format strings that are shared between others can be added to an
+Interpolator as named templates, and included where they are needed:
+
+    err := i.AddTemplate("row", "<td>%cdata;</td><td>%cdata;</td>")
+    result, err := i.InterpStr("<tr>%include:row;</tr>%RAW;", a, b, c)
+
+The template takes as many args as it uses, starting with the include's
+own, so here it gets a and b, and %RAW; gets c. Includes with a named
+argument execute the template with that value as its named data.
+Encoders after the include in its pipeline are applied to the whole of
+the template's output. Templates are resolved when the including format
+string is compiled, so they can include templates that are added later,
+but AddTemplate refuses templates that would end up including
+themselves.
+
+A directive starting with # is a comment, which writes nothing and does
+not consume an arg:
+
+    %# the header row;
+
+Templates kept in files are easier to read when their directives are
+indented on lines of their own. A trim marker, "- " just inside the
+opening delimiter or " -" just inside the closing one, removes all the
+whitespace, including newlines, between the directive and the literal
+text on that side of it:
+
+    <ul>
+        %- each|cdata -;
+    </ul>
+
+The whitespace is removed as the format string is parsed, so it costs
+nothing at all when interpolating. Escaped whitespace is not removed, and
+a dash can be escaped where it would otherwise be taken for a marker, as
+in "%p:a \-;".
+
 There are two different kinds of interpolators you can write, formatters
 and encoders.
 
@@ -108,6 +215,41 @@ pipeline.
 
 See the Encoder documentation below for more gritty details.
 
+Declared Parameters
+
+Rather than parsing their own parameters, formatters and encoders can
+declare them with a ParamSpec, and be added with AddParamFormatter and
+AddParamEncoder:
+
+    spec := strinterp.ParamSpec{
+        {Name: "align", Type: strinterp.EnumParam,
+            Values: []string{"left", "right"}, Default: "left"},
+        {Name: "width", Type: strinterp.IntParam, Positional: true},
+        {Name: "nowrap", Type: strinterp.BoolParam},
+    }
+    err := i.AddParamEncoder("column", spec, column)
+
+The parameters are then a comma-separated list of "name=value" and bare
+values, as in "%column:right,20;" or "%column:width=20,nowrap;". They are
+parsed and checked once, when the format string is compiled, so a bad
+parameter is reported before anything is written, with an
+ErrUnknownArguments that lists what is allowed. The handler receives the
+result as a Params, with typed accessors for each value.
+
+The json formatter and the cdata, base64, pad and trunc encoders of the
+default Interpolator are declared this way. pad and trunc lay text out in
+fixed-width columns, as in "%RAW|trunc:30,ellipsis=…|pad:left,30;",
+measuring it in terminal display columns, so East Asian wide characters
+count double; see Pad and Trunc.
+
+Formatters and encoders that parse their own parameters can still have
+them checked up front, by being added with AddValidatedFormatter or
+AddValidatedEncoder along with a ParamValidator. Interpolator.Validate
+checks a format string, including all of its parameters, without
+interpolating it. Parameters that are neither declared nor validated can
+only be rejected when the directive runs, after the text before it has
+already been written.
+
 Configuring Your Interpolators
 
 To configure your interpolator, you will need to add additional
@@ -131,6 +273,109 @@ Once configured, for maximum utility I recommend putting string
 interpolation into your environment object. See
 http://www.jerf.org/iri/post/2929 .
 
+Changing The Syntax
+
+The % and ; delimiters get in the way when the text being templated is
+itself full of them, as CSS, SQL and shell scripts are. SetSyntax
+configures an Interpolator to use a different Syntax:
+
+    i := strinterp.NewDefaultInterpolator()
+    err := i.SetSyntax(strinterp.BraceSyntax)
+    result, err := i.InterpStr("width: 100%; {{ json | cdata }}", val)
+
+BraceSyntax and DollarSyntax ("${json|cdata}") are provided, or you can
+define your own. Everything else about format strings, including
+escaping, explicit indexes and named arguments, works the same way in
+all Syntaxes, and errors report directives as they were written.
+
+Streaming Format Strings
+
+If the format string itself is large, such as a report template kept in
+a file, use InterpReader to read it from an io.Reader:
+
+    f, err := os.Open("report.tmpl")
+    // ...
+    err = i.InterpReader(w, f, title, body)
+
+Literal text is written straight through to the io.Writer as it is read,
+and directives are processed as they are encountered, so the format
+string is never held in memory in its entirety. The flip side of this is
+that problems with the format string are only discovered once all the
+output before them has already been written.
+
+Going the other way, if you need to hand the result of an interpolation
+to something that wants an io.Reader, such as an http.Request body, use
+NewReader:
+
+    body := i.NewReader("%json;", payload)
+    defer body.Close()
+
+The output is produced as it is read, and closing the reader closes any
+args that are io.ReadClosers.
+
+Compiled Templates
+
+Every call to InterpStr or InterpWriter parses its format string and
+looks up its formatters and encoders all over again. If you are going to
+use the same format string repeatedly, compile it once:
+
+    var greeting = i.MustCompile("<p>Hello, %cdata;!</p>")
+
+    result, err := greeting.String(name)
+
+A *Template holds the already-resolved formatters and encoders, and
+reports malformed format strings and unknown formatters or encoders at
+compile time rather than at first use. Use Template.Execute to write to
+an io.Writer.
+
+SQL Queries
+
+Values should never be escaped into SQL; they should be passed to the
+database as query arguments. In SQL mode, set with SetSQLDialect or
+NewSQLInterpolator, "%arg;" writes a placeholder in the dialect's style
+and collects its value, and "%args;" does the same for each element of a
+slice, for IN lists:
+
+    i, _ := strinterp.NewSQLInterpolator(strinterp.Postgres)
+    query, args, err := i.InterpSQL(
+        "SELECT * FROM t WHERE id = %arg; AND tag IN (%args;)", id, tags)
+    // query is "SELECT * FROM t WHERE id = $1 AND tag IN ($2, $3)"
+    rows, err := db.Query(query, args...)
+
+Identifiers, such as a column chosen to sort by, can't be placeholders.
+The sqlident encoder quotes them for the dialect, and with an allow
+parameter also checks them against a set added with AddIdentifierSet:
+
+    i.AddIdentifierSet("sortable", "name", "created_at")
+    query, args, err := i.InterpSQL(
+        "SELECT * FROM t ORDER BY %sqlident:allow=sortable;", column)
+
+Errors
+
+Problems with the format string itself are returned as a *ParseError,
+which records the byte offset and text of the offending directive, along
+with a snippet pointing at the problem:
+
+    %RAW|blargh;
+         ^
+
+Failures while a directive is being interpolated, such as a formatter
+rejecting its argument or the underlying io.Writer failing, are returned
+as a *DirectiveError. Both wrap the underlying cause, so errors.Is and
+errors.As work as usual:
+
+    if errors.Is(err, strinterp.ErrNotGiven) {
+        // ...
+    }
+
+Parsing Format Strings
+
+Tools that need to understand format strings without interpolating them
+can use Parse, which returns the format string as a list of Nodes:
+Literal text, PercentEscapes, and *Directives, each with its pipeline of
+Stages. Format turns such a list back into a format string, escaping as
+necessary.
+
 Direct Encoder Usage
 
 It is also possible to directly use the Encoders, as their type signature
@@ -166,6 +411,29 @@ them. Generally the entire point of strinterp is to handle potentially
 untrusted input in a safe manner, so if you start "interpreting" user input
 you could be creating openings for attackers.
 
+Encoders are only safe in the context they were written for, so pick the
+one for where the output is going. In HTML, html is for text, and
+htmlattr for attribute values; htmlattr is the only one that is safe for
+unquoted attribute values.
+
+In URLs, urlquery, urlpath and urlfragment encode data for those parts of
+a URL, and url checks a whole URL given by someone else, rejecting
+schemes such as "javascript:" that are not on its list. As URLs usually
+end up in HTML attributes, they still need encoding for that, as in
+"<a href=%url|htmlattr;>".
+
+In scripts, jsstring encodes the contents of a string literal so that it
+can't end the string, or the <script> element it is in. Structured data
+can be embedded as a string with "JSON.parse('%json|jsstring;')".
+
+In CSS, cssstring and cssident encode quoted strings and identifiers.
+Other values, such as colors and lengths, can't be made safe by escaping,
+so csscolor and csslength instead check that the value is exactly what
+it should be.
+
+On shell command lines, sh quotes a single word, and shdq encodes text
+that is already inside double quotes.
+
 Contributing
 
 I'm interested in pull requests for more Formatters and Encoders for the
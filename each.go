@@ -0,0 +1,93 @@
+package strinterp
+
+import (
+	"io"
+	"reflect"
+)
+
+// This file contains the each directive, which applies a pipeline to
+// every element of its arg.
+
+var eachSpec = ParamSpec{
+	{Name: "sep", Type: StringParam},
+}
+
+var boolType = reflect.TypeOf(true)
+
+// execEach runs the directive's pipeline on each element of arg, writing
+// the separator between them directly to w.
+func (i *Interpolator) execEach(w io.Writer, d *directive, arg interface{}) error {
+	first := true
+	return iterate(arg, func(elem interface{}) error {
+		if !first && len(d.sep) > 0 {
+			_, err := w.Write(d.sep)
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		return i.execPipeline(w, d, elem)
+	})
+}
+
+// iterate calls f with each element of the given slice, array, channel or
+// iterator function, which is any func(yield func(T) bool), such as an
+// iter.Seq. It stops at the first error f returns.
+func iterate(arg interface{}, f func(interface{}) error) error {
+	if _, isNotGiven := arg.(NotGivenType); isNotGiven {
+		return ErrNotGiven
+	}
+
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for idx := 0; idx < v.Len(); idx++ {
+			err := f(v.Index(idx).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Chan:
+		if v.Type().ChanDir()&reflect.RecvDir == 0 {
+			break
+		}
+		for {
+			elem, ok := v.Recv()
+			if !ok {
+				return nil
+			}
+			err := f(elem.Interface())
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Func:
+		t := v.Type()
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			break
+		}
+		yieldType := t.In(0)
+		if yieldType.Kind() != reflect.Func || yieldType.NumIn() != 1 ||
+			yieldType.NumOut() != 1 || yieldType.Out(0) != boolType {
+			break
+		}
+		if v.IsNil() {
+			return nil
+		}
+
+		var err error
+		yield := reflect.MakeFunc(yieldType, func(args []reflect.Value) []reflect.Value {
+			// an iterator that ignores being told to stop must not get
+			// to overwrite the error
+			if err == nil {
+				err = f(args[0].Interface())
+			}
+			return []reflect.Value{reflect.ValueOf(err == nil)}
+		})
+		v.Call([]reflect.Value{yield})
+		return err
+	}
+
+	return errNotIterable
+}
@@ -0,0 +1,129 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestEach(t *testing.T) {
+	ch := make(chan interface{}, 3)
+	ch <- "a"
+	ch <- []byte("<b>")
+	ch <- "c"
+	close(ch)
+
+	stopped := false
+	seq := func(yield func(int) bool) {
+		for n := 1; n <= 3; n++ {
+			if !yield(n) {
+				stopped = true
+				return
+			}
+		}
+	}
+
+	tests := []struct {
+		format string
+		arg    interface{}
+		result string
+	}{
+		{`%each:sep=\, |cdata;`, []string{"a", "<b>", "c"}, "a, &lt;b&gt;, c"},
+		{`%each|cdata;`, []string{"a", "b"}, "ab"},
+		{`%each:sep=<br>|cdata;`, [2]string{"<", ">"}, "&lt;<br>&gt;"},
+		{`%each:sep=\,|json;`, []interface{}{1, "x", nil}, "1\n,\"x\"\n,null\n"},
+		{`%each:sep=\;|base64;`, []string{"a", "b"}, "YQ==;Yg=="},
+		{`%each:sep=\,|RAW|base64;`, []string{"a", "b"}, "YQ==,Yg=="},
+		{`%each:sep=\,|RAW;`, ch, "a,<b>,c"},
+		{`%each:sep=-|json;`, seq, "1\n-2\n-3\n"},
+		{`%each:sep=\,|RAW;`, []string{}, ""},
+		{`%each:sep=\,|RAW;`, []string(nil), ""},
+		{`%each:sep=\,|RAW;`, (func(func(string) bool))(nil), ""},
+	}
+
+	i := NewDefaultInterpolator()
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.result {
+			t.Fatal(fmt.Sprintf("for %s, expected %q, got %q (%v)", test.format, test.result, res, err))
+		}
+	}
+	if stopped {
+		t.Fatal("the iterator was stopped early")
+	}
+
+	// an error stops the iteration
+	_, err := i.InterpStr(`%each:sep=\,|RAW;`, seq2(&stopped))
+	if !errors.Is(err, errNoDefaultHandling) || !stopped {
+		t.Fatal("errors do not stop iteration:", err, stopped)
+	}
+	pushes := 0
+	_ = i.AddEncoder("counted", func(w io.Writer, _ []byte) (io.Writer, error) {
+		pushes++
+		return w, nil
+	})
+	_, err = i.InterpStr(`%each|RAW|counted;`, func(yield func(interface{}) bool) {
+		yield(1)
+		yield("ignores being stopped")
+	})
+	if !errors.Is(err, errNoDefaultHandling) || pushes != 1 {
+		t.Fatal("an iterator that ignores being stopped can hide errors:", err, pushes)
+	}
+
+	res, err := i.InterpNamed(`<ul>%@tags|each|cdata;</ul>`, map[string]interface{}{
+		"tags": []string{"<li>"},
+	})
+	if err != nil || res != "<ul>&lt;li&gt;</ul>" {
+		t.Fatal("each does not work with named args:", res, err)
+	}
+	res, err = i.InterpStr(`%[2]each:sep=+|RAW; %[1]RAW;`, "x", []string{"a", "b"})
+	if err != nil || res != "a+b x" {
+		t.Fatal("each does not work with explicit indexes:", res, err)
+	}
+
+	for _, arg := range []interface{}{"abc", 1, nil, make(chan<- int), func(int) {}, NotGiven} {
+		_, err = i.InterpStr(`%each|RAW;`, arg)
+		var de *DirectiveError
+		if !errors.As(err, &de) || (de.Err != errNotIterable && de.Err != ErrNotGiven) {
+			t.Fatal(fmt.Sprintf("for %#v, expected an error, got %v", arg, err))
+		}
+	}
+
+	for format, cause := range map[string]error{
+		"abc%each;":            errEachNoPipeline,
+		"abc%each:x=1|RAW;":    ErrUnknownArguments{},
+		"abc%each|nope;":       errUnknownFormatter("nope"),
+		"abc%each|RAW|nope;":   errUnknownEncoder("nope"),
+		"abc%each|base64:bad;": ErrUnknownArguments{},
+	} {
+		_, err = i.Compile(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || fmt.Sprintf("%T", pe.Err) != fmt.Sprintf("%T", cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %T, got %v", format, cause, err))
+		}
+	}
+	_, err = i.Compile("abc%each|RAW|nope;")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 13 {
+		t.Fatal("each errors are reported at the wrong stage:", err)
+	}
+
+	if i.AddEncoder("each", raw) != errAlreadyExists("each") {
+		t.Fatal("each can be registered as an encoder")
+	}
+}
+
+// seq2 yields values that RAW can not handle, recording whether it was
+// told to stop.
+func seq2(stopped *bool) func(func(interface{}) bool) {
+	return func(yield func(interface{}) bool) {
+		*stopped = false
+		for n := 0; n < 3; n++ {
+			if !yield(n) {
+				*stopped = true
+				return
+			}
+		}
+	}
+}
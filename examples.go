@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"strconv"
+	"strings"
 )
 
 // This file contains the examples of how to write Formatters and Encoders
@@ -37,8 +38,29 @@ func raw(inner io.Writer, params []byte) (io.Writer, error) {
 }
 
 // This next one is slightly more complicated, as it actually handles
-// parameters. This actually returns an io.WriteCloser, but strinterp
-// handles this correctly.
+// parameters. Rather than parsing them itself, it declares them with a
+// ParamSpec, which the Interpolator uses to parse and check them once,
+// when the format string is compiled. If you were going to register this
+// yourself, it would be:
+//
+//  i.AddParamEncoder("base64", base64Spec, base64Encoder)
+//
+// This actually returns an io.WriteCloser, but strinterp handles this
+// correctly.
+
+var base64Spec = ParamSpec{
+	{Name: "encoding", Type: EnumParam, Values: []string{"std", "url"}, Default: "std"},
+}
+
+func base64Encoder(w io.Writer, params Params) (io.Writer, error) {
+	encoding := base64.StdEncoding
+	if params.String("encoding") == "url" {
+		encoding = base64.URLEncoding
+	}
+
+	wc := base64.NewEncoder(encoding, w)
+	return wc, nil
+}
 
 // Base64 defines an Encoder that implements base64 encoding.
 //
@@ -46,32 +68,41 @@ func raw(inner io.Writer, params []byte) (io.Writer, error) {
 // Standard or URL base64 encoding. If no parameter is given, Standard is
 // chosen. Any other parameter results in ErrUnknownArguments.
 func Base64(w io.Writer, args []byte) (io.Writer, error) {
-	encoding := base64.StdEncoding
-	if args != nil {
-		switch string(args) {
-		case "std":
-			// still uses StdEncoding, but does not yield
-			// ErrUnknownArguments
-		case "url":
-			encoding = base64.URLEncoding
-		default:
-			return nil, ErrUnknownArguments{args, "can only be std or url, to indicate the standard or URL base64 encoding"}
-		}
+	params, err := base64Spec.Parse(args)
+	if err != nil {
+		return nil, err
 	}
+	return base64Encoder(w, params)
+}
 
-	wc := base64.NewEncoder(encoding, w)
-	return wc, nil
+var jsonSpec = ParamSpec{
+	{Name: "indent", Type: IntParam},
 }
 
-// JSON defineds a formatter that uses the standard encoding/json module to
-// output JSON.
-func JSON(w io.Writer, val interface{}, params []byte) error {
+func jsonFormatter(w io.Writer, val interface{}, params Params) error {
 	e := json.NewEncoder(w)
+	if params.Int("indent") > 0 {
+		e.SetIndent("", strings.Repeat(" ", params.Int("indent")))
+	}
 	return e.Encode(val)
 }
 
+// JSON defineds a formatter that uses the standard encoding/json module to
+// output JSON.
+//
+// It takes an optional "indent=N" parameter, which indents the JSON by N
+// spaces per level.
+func JSON(w io.Writer, val interface{}, args []byte) error {
+	params, err := jsonSpec.Parse(args)
+	if err != nil {
+		return err
+	}
+	return jsonFormatter(w, val, params)
+}
+
 var hex = "0123456789abcdef"
 
+var amp = []byte("&amp;")
 var lt = []byte("&lt;")
 var gt = []byte("&gt;")
 var cr = []byte("&#13;")
@@ -84,31 +115,42 @@ var apos = []byte("&apos;")
 //
 // There's a lot of history and browser variations here. By default this
 // is a very aggressive encoding function suitable for use in all the
-// parts of HTML that permit "CDATA" that I know of, including attribute
-// values. (Some browsers do not like literal newlines in attributes,
-// considering it to terminate the tag.) However, this aggression may
-// result in difficult-to-read HTML. If you are outputting HTML text as
-// text (as opposed to attribute values), you can pass the argument
-// "nocrlf" to avoid encoding CR and LF as entities.
+// parts of HTML that permit "CDATA" that I know of, including quoted
+// attribute values, which is why it escapes both kinds of quotes. (Some
+// browsers do not like literal newlines in attributes, considering it to
+// terminate the tag.) However, this aggression may result in
+// difficult-to-read HTML. If you are outputting HTML text as text (as
+// opposed to attribute values), you can pass the argument "nocrlf" to
+// avoid encoding CR and LF as entities.
+//
+// CDATA is not safe for unquoted attribute values; see HTMLAttr.
 func CDATA(inner io.Writer, args []byte) (io.Writer, error) {
-	var encodeCRLF = true
-	if args != nil {
-		if string(args) == "nocrlf" {
-			encodeCRLF = false
-		} else {
-			return nil, ErrUnknownArguments{args, "only nocrlf allowed for CDATA"}
-		}
+	params, err := cdataSpec.Parse(args)
+	if err != nil {
+		return nil, err
 	}
+	return cdataEncoder(inner, params)
+}
+
+var cdataSpec = ParamSpec{
+	{Name: "nocrlf", Type: BoolParam},
+}
+
+func cdataEncoder(inner io.Writer, params Params) (io.Writer, error) {
+	var encodeCRLF = !params.Bool("nocrlf")
 
 	return WriterFunc(func(by []byte) (n int, err error) {
 		goodfrom := 0
 
 		for idx, b := range by {
 			// this if clause is "if this is a character we need to encode"
-			if b == '<' || b == '>' ||
+			if b == '<' || b == '>' || b == '&' || b == '"' || b == '\'' ||
 				(b < ' ' && (encodeCRLF || (b != '\n' && b != '\r'))) {
 				if goodfrom != idx {
 					_, err = inner.Write(by[goodfrom:idx])
+					if err != nil {
+						return
+					}
 				}
 				goodfrom = idx + 1
 
@@ -118,6 +160,12 @@ func CDATA(inner io.Writer, args []byte) (io.Writer, error) {
 					_, err = inner.Write(lt)
 				case '>':
 					_, err = inner.Write(gt)
+				case '&':
+					_, err = inner.Write(amp)
+				case '"':
+					_, err = inner.Write(quot)
+				case '\'':
+					_, err = inner.Write(apos)
 				default:
 					// this could be made more efficient with even nastier
 					// code, probably
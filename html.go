@@ -0,0 +1,120 @@
+package strinterp
+
+import (
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// This file contains the HTML encoders, and the machinery for encoders
+// that escape their input a rune at a time.
+
+// escapeRunes returns a writer that writes its input to inner, except for
+// the runes that escape returns a replacement for, which are replaced.
+// Bytes that are not valid UTF-8 are passed to escape as
+// utf8.RuneError, and if escape leaves them be, they are replaced with
+// the UTF-8 encoding of utf8.RuneError.
+//
+// Since an Encoder never receives part of a rune, the writer needs no
+// state of its own.
+func escapeRunes(inner io.Writer, escape func(rune) []byte) io.Writer {
+	return WriterFunc(func(b []byte) (int, error) {
+		goodfrom := 0
+		for idx := 0; idx < len(b); {
+			r, size := rune(b[idx]), 1
+			if r >= utf8.RuneSelf {
+				r, size = utf8.DecodeRune(b[idx:])
+			}
+			replacement := escape(r)
+			if replacement == nil && r == utf8.RuneError && size == 1 {
+				replacement = replacementChar
+			}
+			if replacement != nil {
+				_, err := inner.Write(b[goodfrom:idx])
+				if err != nil {
+					return 0, err
+				}
+				_, err = inner.Write(replacement)
+				if err != nil {
+					return 0, err
+				}
+				goodfrom = idx + size
+			}
+			idx += size
+		}
+
+		if goodfrom < len(b) {
+			_, err := inner.Write(b[goodfrom:])
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	})
+}
+
+var replacementChar = []byte(string(utf8.RuneError))
+
+var htmlEntities = map[rune][]byte{
+	'&':  amp,
+	'<':  lt,
+	'>':  gt,
+	'"':  quot,
+	'\'': apos,
+	'`':  []byte("&#x60;"),
+}
+
+var badHTMLChar = []byte("&#xfffd;")
+
+// HTML defines an Encoder for text in HTML, as opposed to attribute
+// values, following the OWASP recommendations: &, <, >, both quotes and
+// the backtick are escaped as entities. NUL, the control characters that
+// HTML does not allow and invalid UTF-8 are replaced by U+FFFD, as a
+// browser would do. It takes no parameters.
+func HTML(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, htmlEncoder)
+}
+
+func htmlEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return escapeRunes(inner, func(r rune) []byte {
+		if entity := htmlEntities[r]; entity != nil {
+			return entity
+		}
+		if badInHTML(r) {
+			return badHTMLChar
+		}
+		return nil
+	}), nil
+}
+
+// HTMLAttr defines an Encoder for HTML attribute values, which is safe
+// even for unquoted values, as in "<td class=%htmlattr;>". As OWASP
+// recommends, every character below 256 except for ASCII letters and
+// digits is written as a numeric character reference, so that nothing
+// can end the value. Other characters are treated as HTML does. It takes
+// no parameters.
+func HTMLAttr(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, htmlAttrEncoder)
+}
+
+func htmlAttrEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return escapeRunes(inner, func(r rune) []byte {
+		switch {
+		case badInHTML(r):
+			return badHTMLChar
+		case r >= 256 ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return nil
+		}
+		return []byte("&#x" + strconv.FormatInt(int64(r), 16) + ";")
+	}), nil
+}
+
+// badInHTML returns whether the rune can not appear in HTML, even as a
+// character reference.
+func badInHTML(r rune) bool {
+	if r < ' ' {
+		return r != '\t' && r != '\n' && r != '\f' && r != '\r'
+	}
+	return (r >= 0x7f && r < 0xa0) || r == utf8.RuneError
+}
@@ -0,0 +1,105 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		format   string
+		arg      string
+		expected string
+	}{
+		{"%html;", "", ""},
+		{"%html;", "plain text, é日", "plain text, é日"},
+		{"%html;", "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"%html;", `"'` + "`", "&quot;&apos;&#x60;"},
+		{"%html;", "line\n\ttab\r", "line\n\ttab\r"},
+		{"%html;", "nul\x00\x1b\u0085", "nul&#xfffd;&#xfffd;&#xfffd;"},
+		{"%html;", "bad\xffutf8", "bad&#xfffd;utf8"},
+
+		// entities in the input are text, and are encoded again, so that
+		// the browser shows them as they were given
+		{"%html;", "&amp;", "&amp;amp;"},
+		{"%html;", "&#60;&lt", "&amp;#60;&amp;lt"},
+		{"%html|html;", "&", "&amp;amp;"},
+
+		{"%htmlattr;", "abcXYZ019", "abcXYZ019"},
+		{"%htmlattr;", "a b", "a&#x20;b"},
+		{"%htmlattr;", "é日", "&#xe9;日"},
+		{"%htmlattr;", "&amp;", "&#x26;amp&#x3b;"},
+		{"%htmlattr;", "\x00\x7f", "&#xfffd;&#xfffd;"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %q, got %q, %v",
+				test.format, test.arg, test.expected, res, err))
+		}
+	}
+
+	for _, params := range []string{"x", "nocrlf"} {
+		for _, encoder := range []Encoder{HTML, HTMLAttr} {
+			_, err := encoder(nil, []byte(params))
+			var ua ErrUnknownArguments
+			if !errors.As(err, &ua) {
+				t.Fatal(fmt.Sprintf("for %s, expected bad arguments, got %v", params, err))
+			}
+		}
+	}
+}
+
+// Attribute values must not be able to break out of their attribute, for
+// all the ways the attribute can be written.
+func TestHTMLAttributeBreakout(t *testing.T) {
+	i := NewDefaultInterpolator()
+	attacks := []string{
+		`" onmouseover="alert(1)`,
+		`' onmouseover='alert(1)`,
+		"` onmouseover=`alert(1)",
+		"x onmouseover=alert(1)",
+		"x\tonmouseover=alert(1)",
+		"x\nonmouseover=alert(1)",
+		"x/onmouseover=alert(1)",
+		"x><script>alert(1)</script>",
+		"x&#34; onmouseover=alert(1)",
+	}
+
+	contexts := []struct {
+		open, encoder, close string
+		// the bytes that would end the attribute, or start a reference
+		breakers string
+	}{
+		{`<a title="`, "html", `">`, `"<>&`},
+		{`<a title='`, "html", `'>`, `'<>&`},
+		{`<a title="`, "cdata", `">`, `"<>&`},
+		{`<a title='`, "cdata", `'>`, `'<>&`},
+		{`<a title="`, "htmlattr", `">`, `"<>&`},
+		{`<a title=`, "htmlattr", `>`, " \t\n\f\r\"'`=<>&/"},
+	}
+
+	for _, attack := range attacks {
+		for _, c := range contexts {
+			res, err := i.InterpStr(c.open+"%"+c.encoder+";"+c.close, attack)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value := strings.TrimSuffix(strings.TrimPrefix(res, c.open), c.close)
+
+			// every & must start one of the references the encoders
+			// write, and nothing else may end the value
+			unescaped := htmlReference.ReplaceAllString(value, "")
+			if strings.ContainsAny(unescaped, c.breakers) {
+				t.Fatal(fmt.Sprintf("for %s, %q broke out: %s", c.encoder, attack, res))
+			}
+		}
+	}
+}
+
+var htmlReference = regexp.MustCompile(`&(amp|lt|gt|quot|apos|#x[0-9a-f]+|#[0-9]+);`)
@@ -0,0 +1,147 @@
+package strinterp
+
+import (
+	"strings"
+)
+
+// This file contains named templates, and the include directive that
+// executes them as part of another format string.
+
+var includeSpec = ParamSpec{
+	{Name: "template", Type: StringParam, Positional: true},
+}
+
+// A namedTemplate is a format string added with AddTemplate, along with
+// the Syntax it was written in and the templates it includes.
+type namedTemplate struct {
+	format   []byte
+	syntax   Syntax
+	includes []string
+}
+
+// AddTemplate adds a named format string to the interpolator, which other
+// format strings can then include with "%include:name;".
+//
+// The format string is parsed with the Interpolator's current Syntax,
+// and any problem parsing it is returned. Its formatters, encoders and
+// included templates are not resolved until it is included, so they can
+// be added afterwards. Adding a template that would end up including
+// itself is an error.
+//
+// If the name is already used by another template, an error will be
+// returned.
+func (i *Interpolator) AddTemplate(name string, format string) error {
+	if _, exists := i.templates[name]; exists {
+		return errTemplateExists(name)
+	}
+
+	nodes, err := i.syntax.Parse(format)
+	if err != nil {
+		return err
+	}
+	tmpl := namedTemplate{format: []byte(format), syntax: i.syntax}
+	for _, node := range nodes {
+		d, isDirective := node.(*Directive)
+		if !isDirective || d.Stages[0].Name != "include" {
+			continue
+		}
+		params, err := includeSpec.Parse(d.Stages[0].Params)
+		if err != nil {
+			return err
+		}
+		tmpl.includes = append(tmpl.includes, params.String("template"))
+	}
+
+	// the templates already added can't include each other in a cycle,
+	// so any cycle has to go through the new one
+	cycle := i.findCycle(name, tmpl.includes, []string{name})
+	if cycle != nil {
+		return errTemplateCycle(strings.Join(cycle, " -> "))
+	}
+
+	i.templates[name] = tmpl
+	return nil
+}
+
+// findCycle searches the given includes, and everything they include in
+// turn, for the named template, returning the chain of includes that
+// leads to it. path is the chain of includes so far.
+func (i *Interpolator) findCycle(name string, includes []string, path []string) []string {
+	for _, include := range includes {
+		chain := append(path[:len(path):len(path)], include)
+		if include == name {
+			return chain
+		}
+		cycle := i.findCycle(name, i.templates[include].includes, chain)
+		if cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// compileInclude resolves an include directive, compiling the template it
+// includes.
+func (i *Interpolator) compileInclude(n *Directive) (*directive, int, error) {
+	params, err := includeSpec.Parse(n.Stages[0].Params)
+	if err != nil {
+		return nil, 0, err
+	}
+	name := params.String("template")
+	if name == "" {
+		return nil, 0, errIncludeNoTemplate
+	}
+	tmpl, exists := i.templates[name]
+	if !exists {
+		return nil, 0, errUnknownTemplate(name)
+	}
+
+	d := &directive{}
+	d.template, err = i.compileWith(tmpl.format, &tmpl.syntax)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline, at, err := i.compilePipeline(n.Stages[1:], 1)
+	if err != nil {
+		return nil, at, err
+	}
+	d.pipeline = pipeline
+	return d, 0, nil
+}
+
+// include executes an include directive. The template receives the args
+// starting with the directive's own, as many as it uses, or the named arg
+// as its data for named arguments. Its output is written through the
+// directive's pipeline.
+func (e *execution) include(d *directive) error {
+	sub := &execution{i: e.i, named: e.named, strict: e.strict, sql: e.sql}
+	switch {
+	case d.path != nil:
+		sub.named = lookupName(e.named, d.path)
+	case d.arg < len(e.args):
+		end := d.arg + len(d.template.used)
+		if end > len(e.args) {
+			end = len(e.args)
+		}
+		sub.args = e.args[d.arg:end]
+	case d.explicitArg:
+		return &DirectiveError{d.offset, d.index, d.raw, errArgIndexOutOfRange}
+	}
+
+	writer, err := newPipelineWriter(e.w, d.pipeline)
+	if err != nil {
+		return &DirectiveError{d.offset, d.index, d.raw, err}
+	}
+	sub.w = writer
+
+	err = d.template.execute(sub)
+	err2 := writer.Finish()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		return &DirectiveError{d.offset, d.index, d.raw, err}
+	}
+	return nil
+}
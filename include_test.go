@@ -0,0 +1,125 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	i := NewDefaultInterpolator()
+	for name, format := range map[string]string{
+		"row":    "<td>%cdata;</td><td>%cdata;</td>",
+		"table":  "<table>%include:row;%include:template=row;</table>",
+		"later":  "%include:footer;",
+		"none":   "-",
+		"named":  "<b>%@Name|cdata;</b>",
+		"indexy": "%[2]RAW;%[1]RAW;",
+	} {
+		err := i.AddTemplate(name, format)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("could not add %s: %v", name, err))
+		}
+	}
+	// templates may include templates that are added after them
+	err := i.AddTemplate("footer", "(%RAW;)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format   string
+		args     []interface{}
+		expected string
+	}{
+		{"a%include:row;b", []interface{}{"<", ">"}, "a<td>&lt;</td><td>&gt;</td>b"},
+		{"%include:table;", []interface{}{"1", "2", "3", "4"},
+			"<table><td>1</td><td>2</td><td>3</td><td>4</td></table>"},
+		{"%RAW;%include:none;%RAW;", []interface{}{"1", "2"}, "1-2"},
+		{"%include:row;%RAW;", []interface{}{"1", "2", "3"}, "<td>1</td><td>2</td>3"},
+		{"%[2]include:indexy;", []interface{}{"1", "2", "3"}, "32"},
+		{"%include:later|base64;", []interface{}{"x"}, "KHgp"},
+		{"%include:row|base64;", []interface{}{"<", "b"}, "PHRkPiZsdDs8L3RkPjx0ZD5iPC90ZD4="},
+		{"%if;%include:footer;%end;", []interface{}{false, "x"}, ""},
+		{"%if;%include:footer;%end;", []interface{}{true, "x"}, "(x)"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.args...)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s, expected %q, got %q, %v", test.format, test.expected, res, err))
+		}
+		w := &recordingWriter{}
+		err = i.InterpReader(w, strings.NewReader(test.format), test.args...)
+		if err != nil || w.String() != test.expected {
+			t.Fatal(fmt.Sprintf("for %s, streaming got %q, %v", test.format, w.String(), err))
+		}
+	}
+
+	res, err := i.InterpNamed("%@user|include:named;", map[string]interface{}{
+		"user": map[string]interface{}{"Name": "<me>"},
+	})
+	if err != nil || res != "<b>&lt;me&gt;</b>" {
+		t.Fatal("includes do not use named args:", res, err)
+	}
+
+	// the template's args count for arity checks
+	i.SetStrict(true)
+	_, err = i.InterpStr("%include:row;", 1)
+	var missing ErrMissingArguments
+	if !errors.As(err, &missing) {
+		t.Fatal("includes are not arity checked:", err)
+	}
+	_, err = i.InterpStr("%include:row;%RAW;", "1", "2", "3")
+	if err != nil {
+		t.Fatal("strict includes fail:", err)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	i := NewDefaultInterpolator()
+	if i.AddTemplate("a", "%include:b;") != nil || i.AddTemplate("b", "%include:c;x") != nil ||
+		i.AddTemplate("leaf", "x") != nil {
+		t.Fatal("could not add templates")
+	}
+	for name, chain := range map[string]string{
+		"c": "c -> a -> b -> c",
+		"d": "d -> d",
+	} {
+		err := i.AddTemplate(name, "%include:a;%include:"+name+";")
+		if err != errTemplateCycle(chain) {
+			t.Fatal(fmt.Sprintf("for %s, expected a cycle through %s, got %v", name, chain, err))
+		}
+	}
+	if i.AddTemplate("a", "") != errTemplateExists("a") {
+		t.Fatal("templates can be added twice")
+	}
+	var pe *ParseError
+	if err := i.AddTemplate("bad", "%RAW"); !errors.As(err, &pe) {
+		t.Fatal("bad templates can be added:", err)
+	}
+	if err := i.AddTemplate("badinclude", "%include:x,y;"); err == nil {
+		t.Fatal("bad includes can be added")
+	}
+	if i.AddFormatter("include", JSON) != errAlreadyExists("include") {
+		t.Fatal("include is not a keyword")
+	}
+
+	for format, cause := range map[string]error{
+		"abc%include;":           errIncludeNoTemplate,
+		"abc%include:nope;":      errUnknownTemplate("nope"),
+		"abc%include:a;":         errUnknownTemplate("c"),
+		"abc%include:leaf|nope;": errUnknownEncoder("nope"),
+	} {
+		_, err := i.Compile(format)
+		if !errors.As(err, &pe) || pe.Offset < 4 || !errors.Is(err, cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %v, got %v", format, cause, err))
+		}
+	}
+	_, err := i.Compile("%include:b,c;")
+	var ua ErrUnknownArguments
+	if !errors.As(err, &ua) {
+		t.Fatal("include does not check its parameters:", err)
+	}
+}
@@ -0,0 +1,111 @@
+package strinterp
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// This file contains the JavaScript string encoder.
+
+var jsStringSpec = ParamSpec{
+	{Name: "quote", Type: EnumParam, Values: []string{"single", "double", "template"}},
+}
+
+var jsQuotes = map[string]string{
+	"single":   "'",
+	"double":   `"`,
+	"template": "`",
+}
+
+// JSString defines an Encoder for the contents of a JavaScript string
+// literal, which is safe even in an inline <script> block or an event
+// handler attribute.
+//
+// Quotes of all three kinds, backslashes, control characters, U+2028 and
+// U+2029, and "<>&/" are written as \uXXXX escapes, so that nothing can
+// end the string, or the script element, or start an HTML comment. As
+// \uXXXX is the only escape used, the result is also valid inside a JSON
+// string.
+//
+// With the "quote" parameter, which is "single", "double" or "template",
+// the encoder writes the quotes around the string itself, as in
+// "var name = %jsstring:quote=single;;". "$" is also escaped in template
+// literals, and when the encoder does not know which quotes are used, so
+// that nothing can be interpolated into them.
+func JSString(inner io.Writer, args []byte) (io.Writer, error) {
+	params, err := jsStringSpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return jsStringEncoder(inner, params)
+}
+
+func jsStringEncoder(inner io.Writer, params Params) (io.Writer, error) {
+	quote := jsQuotes[params.String("quote")]
+	escapeDollar := quote == "" || quote == "`"
+
+	escaped := escapeRunes(inner, func(r rune) []byte {
+		switch r {
+		case '\\', '"', '\'', '`', '<', '>', '&', '/', 0x7f, 0x2028, 0x2029:
+			return jsEscape(r)
+		case '$':
+			if escapeDollar {
+				return jsEscape(r)
+			}
+		case utf8.RuneError:
+			return jsEscape(r)
+		}
+		if r < ' ' {
+			return jsEscape(r)
+		}
+		return nil
+	})
+	if quote == "" {
+		return escaped, nil
+	}
+	return &quotingWriter{inner: inner, escaped: escaped, quote: []byte(quote)}, nil
+}
+
+// jsEscape returns the \uXXXX escape for a rune in the Basic Multilingual
+// Plane.
+func jsEscape(r rune) []byte {
+	return []byte{'\\', 'u', hex[r>>12&15], hex[r>>8&15], hex[r>>4&15], hex[r&15]}
+}
+
+// A quotingWriter writes quotes around the output of an escaping writer,
+// which writes to inner.
+type quotingWriter struct {
+	inner   io.Writer
+	escaped io.Writer
+	quote   []byte
+	// set once the opening quote has been written
+	opened bool
+}
+
+func (qw *quotingWriter) open() error {
+	if qw.opened {
+		return nil
+	}
+	qw.opened = true
+	_, err := qw.inner.Write(qw.quote)
+	return err
+}
+
+func (qw *quotingWriter) Write(b []byte) (int, error) {
+	err := qw.open()
+	if err != nil {
+		return 0, err
+	}
+	return qw.escaped.Write(b)
+}
+
+// Close writes the closing quote, along with the opening one if nothing
+// has been written.
+func (qw *quotingWriter) Close() error {
+	err := qw.open()
+	if err != nil {
+		return err
+	}
+	_, err = qw.inner.Write(qw.quote)
+	return err
+}
@@ -0,0 +1,69 @@
+package strinterp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJSString(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		format   string
+		arg      string
+		expected string
+	}{
+		{"%jsstring;", "", ""},
+		{"%jsstring;", "plain text é日𝄞", "plain text é日𝄞"},
+		{"%jsstring:quote=double;", "", `""`},
+		{"%jsstring:quote=single;", "it's", `'it\u0027s'`},
+		{"%jsstring:double;", `say "hi"`, `"say \u0022hi\u0022"`},
+		{"%jsstring:template;", "${alert(1)}`", "`\\u0024{alert(1)}\\u0060`"},
+		{"%jsstring:single;", "$x", "'$x'"},
+		{"%jsstring;", "$x", `\u0024x`},
+
+		// breaking out of the string
+		{"%jsstring;", `\`, `\u005c`},
+		{"%jsstring;", `\"`, `\u005c\u0022`},
+		{"%jsstring;", "a\nb\r\x00\x1f\x7f", `a\u000ab\u000d\u0000\u001f\u007f`},
+		{"%jsstring;", "\u2028\u2029", `\u2028\u2029`},
+
+		// breaking out of the script element, or into an HTML comment
+		{"%jsstring;", "</script><script>alert(1)</script>",
+			`\u003c\u002fscript\u003e\u003cscript\u003ealert(1)\u003c\u002fscript\u003e`},
+		{"%jsstring;", "<!-- -->", `\u003c!-- --\u003e`},
+		{"%jsstring;", "]]>", `]]\u003e`},
+		{"%jsstring;", "&quot;", `\u0026quot;`},
+
+		// invalid UTF-8 can't be used to hide anything
+		{"%jsstring;", "\xc0\"\xff", `\ufffd\u0022\ufffd`},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %s, got %s, %v",
+				test.format, test.arg, test.expected, res, err))
+		}
+		if strings.ContainsAny(res, "<>&/\n\r\u2028\u2029") {
+			t.Fatal(fmt.Sprintf("for %q, dangerous characters survived: %s", test.arg, res))
+		}
+	}
+
+	// the result means what was put in, however it is written
+	for _, s := range []string{"a'b\"c`d\\e", "</script>", "日本\u2028\x01$"} {
+		buf := &recordingWriter{}
+		enc, _ := JSString(buf, []byte("double"))
+		for _, r := range s {
+			_, _ = enc.Write([]byte(string(r)))
+		}
+		_ = enc.(*quotingWriter).Close()
+
+		var decoded string
+		err := json.Unmarshal(buf.Bytes(), &decoded)
+		if err != nil || decoded != s {
+			t.Fatal(fmt.Sprintf("for %q, got %s, which decodes to %q, %v", s, buf.String(), decoded, err))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package strinterp
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// This file contains the resolution of named arguments.
+
+// InterpNamed is a convenience function that does interpolation on a
+// format string using named arguments, and returns the resulting string.
+// See Template.ExecuteNamed for how names are resolved against data.
+func (i *Interpolator) InterpNamed(format string, data interface{}) (string, error) {
+	t, err := i.Compile(format)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	err = t.ExecuteNamed(buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// lookupName resolves the given path against data, returning NotGiven if
+// any component of it can not be found.
+func lookupName(data interface{}, path []string) interface{} {
+	v := reflect.ValueOf(data)
+	for _, name := range path {
+		v = indirect(v)
+		if !v.IsValid() {
+			return NotGiven
+		}
+
+		switch v.Kind() {
+		case reflect.Map:
+			keyType := v.Type().Key()
+			if keyType.Kind() != reflect.String {
+				return NotGiven
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(keyType))
+		case reflect.Struct:
+			v = structField(v, name)
+		default:
+			return NotGiven
+		}
+
+		if !v.IsValid() {
+			return NotGiven
+		}
+	}
+
+	if !v.IsValid() {
+		return NotGiven
+	}
+	return v.Interface()
+}
+
+// indirect follows pointers and interfaces down to the value they
+// contain, returning the zero Value if it runs into a nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// structField returns the exported field of the struct that goes by the
+// given name, or the zero Value if there isn't one.
+func structField(v reflect.Value, name string) reflect.Value {
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldName := field.Name
+		tag, hasTag := field.Tag.Lookup("strinterp")
+		if tag == "-" {
+			continue
+		}
+		if hasTag && tag != "" {
+			fieldName = tag
+		}
+
+		if fieldName == name {
+			// this fails if the field is promoted through a nil pointer
+			fv, err := v.FieldByIndexErr(field.Index)
+			if err != nil {
+				return reflect.Value{}
+			}
+			return fv
+		}
+	}
+	return reflect.Value{}
+}
@@ -0,0 +1,468 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file contains the declared, structured form of formatter and
+// encoder parameters.
+
+// A ParamType is the type of the value of a Param.
+type ParamType int
+
+// The types of values a Param can take.
+const (
+	// StringParam accepts any value.
+	StringParam ParamType = iota
+	// IntParam accepts a base-10 integer.
+	IntParam
+	// BoolParam is set to true by giving its name by itself, as in
+	// "%cdata:nocrlf;", or explicitly with "name=true" or "name=false".
+	BoolParam
+	// EnumParam accepts one of a fixed set of Values. A value can be
+	// given by itself, as in "%base64:url;", as well as with
+	// "name=value".
+	EnumParam
+)
+
+// A Param declares a single parameter of a formatter or encoder.
+type Param struct {
+	Name string
+	Type ParamType
+	// Values is the set of allowed values of an EnumParam.
+	Values []string
+	// Default is the value the Param has if it is not given, written as
+	// it would be in a format string. If it is empty, the Param has the
+	// zero value of its type.
+	Default string
+	// Positional allows the value of a StringParam, IntParam or
+	// EnumParam to be given by itself, as in "%pad:20;". Values given by
+	// themselves that do not name a BoolParam or match the Values of an
+	// EnumParam fill in the Positional Params in the order they are
+	// declared.
+	Positional bool
+}
+
+// A ParamSpec declares the parameters a formatter or encoder accepts.
+//
+// Parameters are given as a comma-separated list of "name=value" and
+// bare values, as in "%each:sep=\, |cdata;". Within the list, a backslash
+// escapes a comma, an equals sign or another backslash, and is passed
+// through in front of anything else.
+type ParamSpec []Param
+
+// Params are the parsed parameters of a formatter or encoder, as declared
+// by its ParamSpec. Params that were not given have their default value.
+//
+// Asking for a Param that was not declared, or asking for it as the wrong
+// type, yields the zero value of that type.
+type Params struct {
+	raw    []byte
+	values map[string]interface{}
+	given  map[string]bool
+}
+
+// String returns the value of a StringParam or EnumParam.
+func (p Params) String(name string) string {
+	s, _ := p.values[name].(string)
+	return s
+}
+
+// Int returns the value of an IntParam.
+func (p Params) Int(name string) int {
+	i, _ := p.values[name].(int)
+	return i
+}
+
+// Bool returns the value of a BoolParam.
+func (p Params) Bool(name string) bool {
+	b, _ := p.values[name].(bool)
+	return b
+}
+
+// Given returns whether the Param was explicitly given in the format
+// string, as opposed to having its default value.
+func (p Params) Given(name string) bool {
+	return p.given[name]
+}
+
+// Raw returns the parameters exactly as the format string gave them.
+func (p Params) Raw() []byte {
+	return p.raw
+}
+
+// A ParamFormatter is a Formatter that receives its parameters parsed
+// according to its ParamSpec. See Interpolator.AddParamFormatter.
+type ParamFormatter func(w io.Writer, val interface{}, params Params) error
+
+// A ParamEncoder is an Encoder that receives its parameters parsed
+// according to its ParamSpec. See Interpolator.AddParamEncoder.
+type ParamEncoder func(w io.Writer, params Params) (io.Writer, error)
+
+// A ParamValidator checks the parameters of a Formatter or Encoder,
+// returning an error if the Formatter or Encoder would reject them. See
+// Interpolator.AddValidatedEncoder.
+type ParamValidator func(params []byte) error
+
+type paramFormatter struct {
+	spec    ParamSpec
+	handler ParamFormatter
+}
+
+type paramEncoder struct {
+	spec    ParamSpec
+	handler ParamEncoder
+}
+
+// AddParamFormatter adds a formatter whose parameters are declared by the
+// given ParamSpec. The parameters are parsed and checked once, when the
+// format string is compiled, and any problem is reported then as an
+// ErrUnknownArguments, so the handler only ever sees valid Params.
+//
+// If the format string is already registered, or the ParamSpec is
+// inconsistent, an error will be returned.
+func (i *Interpolator) AddParamFormatter(format string, spec ParamSpec, handler ParamFormatter) error {
+	if i.registered(format) {
+		return errAlreadyExists(format)
+	}
+	err := spec.validate()
+	if err != nil {
+		return err
+	}
+
+	i.paramFormatters[format] = paramFormatter{spec, handler}
+
+	return nil
+}
+
+// AddParamEncoder adds an encoder whose parameters are declared by the
+// given ParamSpec. See AddParamFormatter.
+func (i *Interpolator) AddParamEncoder(format string, spec ParamSpec, handler ParamEncoder) error {
+	if i.registered(format) {
+		return errAlreadyExists(format)
+	}
+	err := spec.validate()
+	if err != nil {
+		return err
+	}
+
+	i.paramEncoders[format] = paramEncoder{spec, handler}
+
+	return nil
+}
+
+// AddValidatedFormatter adds a formatter along with a function that checks
+// its parameters. The parameters of every directive using the formatter
+// are checked when the format string is compiled, so bad parameters are
+// reported before anything is written, rather than when the formatter
+// is called.
+//
+// If the format string is already registered, an error will be returned.
+func (i *Interpolator) AddValidatedFormatter(format string, handler Formatter, validator ParamValidator) error {
+	err := i.AddFormatter(format, handler)
+	if err != nil {
+		return err
+	}
+	i.validators[format] = validator
+	return nil
+}
+
+// AddValidatedEncoder adds an encoder along with a function that checks
+// its parameters. See AddValidatedFormatter.
+func (i *Interpolator) AddValidatedEncoder(format string, handler Encoder, validator ParamValidator) error {
+	err := i.AddEncoder(format, handler)
+	if err != nil {
+		return err
+	}
+	i.validators[format] = validator
+	return nil
+}
+
+// Validate checks the format string without interpolating it. Malformed
+// format strings, unknown formatters and encoders, and parameters
+// rejected by the ParamSpecs and ParamValidators of the formatters and
+// encoders are all reported as a *ParseError.
+//
+// Formatters and encoders added without either can only reject their
+// parameters when they are called.
+func (i *Interpolator) Validate(format string) error {
+	_, err := i.Compile(format)
+	return err
+}
+
+// encodeNoParams is the Encoder for a ParamEncoder that takes no
+// parameters, rejecting any that are given.
+func encodeNoParams(inner io.Writer, args []byte, encoder ParamEncoder) (io.Writer, error) {
+	_, err := ParamSpec{}.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return encoder(inner, Params{})
+}
+
+// validator returns a ParamValidator that parses the parameters with the
+// ParamSpec, and then checks them with check, for the rules that the
+// ParamSpec can't express.
+func (ps ParamSpec) validator(check func(Params) error) ParamValidator {
+	return func(args []byte) error {
+		params, err := ps.Parse(args)
+		if err != nil {
+			return err
+		}
+		return check(params)
+	}
+}
+
+// validate checks that the spec is unambiguous, and that its defaults are
+// valid.
+func (ps ParamSpec) validate() error {
+	names := map[string]bool{}
+	bare := map[string]bool{}
+	for _, param := range ps {
+		if param.Name == "" || names[param.Name] {
+			return errBadParamSpec("parameter names must be unique and non-empty")
+		}
+		names[param.Name] = true
+
+		switch param.Type {
+		case BoolParam:
+			if param.Positional {
+				return errBadParamSpec(param.Name + " is a boolean, and can not be positional")
+			}
+			if bare[param.Name] {
+				return errBadParamSpec(param.Name + " is ambiguous")
+			}
+			bare[param.Name] = true
+		case EnumParam:
+			if len(param.Values) == 0 {
+				return errBadParamSpec(param.Name + " has no allowed values")
+			}
+			for _, value := range param.Values {
+				if bare[value] {
+					return errBadParamSpec(value + " is ambiguous")
+				}
+				bare[value] = true
+			}
+		case StringParam, IntParam:
+		default:
+			return errBadParamSpec(param.Name + " has an unknown type")
+		}
+
+		if param.Default != "" {
+			_, err := param.parse([]byte(param.Default))
+			if err != nil {
+				return errBadParamSpec("bad default for " + param.Name + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Parse parses the parameters given to a formatter or encoder according
+// to the ParamSpec. This is what the Interpolator does for handlers added
+// with AddParamFormatter and AddParamEncoder, and is public so that
+// Formatters and Encoders can use ParamSpecs when they are used directly.
+//
+// Problems with the parameters are reported as an ErrUnknownArguments
+// that describes what the ParamSpec accepts.
+func (ps ParamSpec) Parse(params []byte) (Params, error) {
+	p := Params{raw: params}
+	// most encoders take no parameters, so they don't need the maps
+	if len(ps) > 0 {
+		p.values = map[string]interface{}{}
+		p.given = map[string]bool{}
+	}
+	for _, param := range ps {
+		value, _ := param.parse([]byte(param.Default))
+		p.values[param.Name] = value
+	}
+
+	fail := func(problem string) (Params, error) {
+		return Params{}, ErrUnknownArguments{params, problem + "; " + ps.describe()}
+	}
+
+	positional := 0
+	for _, item := range splitParams(params) {
+		var param *Param
+		var value []byte
+
+		if item.hasValue {
+			param = ps.find(string(item.key))
+			if param == nil {
+				return fail("unknown parameter " + strconv.Quote(string(item.key)))
+			}
+			value = item.value
+		} else {
+			param, value = ps.findBare(string(item.key), &positional)
+			if param == nil {
+				return fail("unknown parameter " + strconv.Quote(string(item.key)))
+			}
+		}
+
+		if p.given[param.Name] {
+			return fail(param.Name + " given more than once")
+		}
+		parsed, err := param.parse(value)
+		if err != nil {
+			return fail(err.Error())
+		}
+		p.values[param.Name] = parsed
+		p.given[param.Name] = true
+	}
+
+	return p, nil
+}
+
+// Validate checks the parameters given to a formatter or encoder against
+// the ParamSpec, without keeping the result. It is a ParamValidator.
+func (ps ParamSpec) Validate(params []byte) error {
+	_, err := ps.Parse(params)
+	return err
+}
+
+func (ps ParamSpec) find(name string) *Param {
+	for idx := range ps {
+		if ps[idx].Name == name {
+			return &ps[idx]
+		}
+	}
+	return nil
+}
+
+// findBare resolves a value given by itself, returning the Param it is
+// for and the value it should be parsed as. positional tracks how many
+// Positional Params have been filled so far.
+func (ps ParamSpec) findBare(word string, positional *int) (*Param, []byte) {
+	for idx := range ps {
+		param := &ps[idx]
+		if param.Type == BoolParam && param.Name == word {
+			return param, []byte("true")
+		}
+		if param.Type == EnumParam {
+			for _, value := range param.Values {
+				if value == word {
+					return param, []byte(word)
+				}
+			}
+		}
+	}
+
+	seen := 0
+	for idx := range ps {
+		if !ps[idx].Positional {
+			continue
+		}
+		if seen == *positional {
+			*positional++
+			return &ps[idx], []byte(word)
+		}
+		seen++
+	}
+	return nil, nil
+}
+
+func (param *Param) parse(value []byte) (interface{}, error) {
+	switch param.Type {
+	case IntParam:
+		if len(value) == 0 {
+			return 0, nil
+		}
+		i, err := strconv.Atoi(string(value))
+		if err != nil {
+			return nil, errBadParamValue(param.Name + " must be an integer")
+		}
+		return i, nil
+	case BoolParam:
+		if len(value) == 0 {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(string(value))
+		if err != nil {
+			return nil, errBadParamValue(param.Name + " must be true or false")
+		}
+		return b, nil
+	case EnumParam:
+		if len(value) == 0 {
+			return "", nil
+		}
+		for _, allowed := range param.Values {
+			if string(value) == allowed {
+				return allowed, nil
+			}
+		}
+		return nil, errBadParamValue(param.Name + " must be one of " +
+			strings.Join(param.Values, ", "))
+	}
+	return string(value), nil
+}
+
+// describe lists the parameters the spec accepts, for error messages.
+func (ps ParamSpec) describe() string {
+	if len(ps) == 0 {
+		return "no parameters are allowed"
+	}
+	descriptions := make([]string, 0, len(ps))
+	for _, param := range ps {
+		switch param.Type {
+		case IntParam:
+			descriptions = append(descriptions, param.Name+"=<integer>")
+		case BoolParam:
+			descriptions = append(descriptions, param.Name)
+		case EnumParam:
+			descriptions = append(descriptions, param.Name+"="+strings.Join(param.Values, "|"))
+		default:
+			descriptions = append(descriptions, param.Name+"=<string>")
+		}
+	}
+	return "allowed parameters are " + strings.Join(descriptions, ", ")
+}
+
+type paramItem struct {
+	key      []byte
+	value    []byte
+	hasValue bool
+}
+
+// splitParams splits parameters into their comma-separated items, and
+// each item into its name and value, honoring backslash escapes.
+func splitParams(params []byte) []paramItem {
+	if len(params) == 0 {
+		return nil
+	}
+
+	items := []paramItem{}
+	item := paramItem{}
+	current := []byte{}
+	for idx := 0; idx < len(params); idx++ {
+		b := params[idx]
+		switch {
+		case b == '\\' && idx+1 < len(params) &&
+			bytes.IndexByte([]byte(`\,=`), params[idx+1]) != -1:
+			idx++
+			current = append(current, params[idx])
+		case b == ',':
+			items = append(items, item.finish(current))
+			item = paramItem{}
+			current = []byte{}
+		case b == '=' && !item.hasValue:
+			item.key = current
+			item.hasValue = true
+			current = []byte{}
+		default:
+			current = append(current, b)
+		}
+	}
+	return append(items, item.finish(current))
+}
+
+func (item paramItem) finish(current []byte) paramItem {
+	if item.hasValue {
+		item.value = current
+	} else {
+		item.key = current
+	}
+	return item
+}
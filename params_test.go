@@ -0,0 +1,231 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSpec = ParamSpec{
+	{Name: "align", Type: EnumParam, Values: []string{"left", "right"}, Default: "left"},
+	{Name: "width", Type: IntParam, Positional: true},
+	{Name: "fill", Type: StringParam, Default: " "},
+	{Name: "strict", Type: BoolParam},
+}
+
+func TestParamSpecParse(t *testing.T) {
+	tests := []struct {
+		params []byte
+		values map[string]interface{}
+		given  []string
+	}{
+		{nil, map[string]interface{}{"align": "left", "width": 0, "fill": " ", "strict": false}, nil},
+		{[]byte{}, map[string]interface{}{"align": "left", "width": 0, "fill": " ", "strict": false}, nil},
+		{[]byte("right,20"), map[string]interface{}{"align": "right", "width": 20, "fill": " ", "strict": false},
+			[]string{"align", "width"}},
+		{[]byte("strict,fill=.,width=-3"), map[string]interface{}{"align": "left", "width": -3, "fill": ".", "strict": true},
+			[]string{"strict", "fill", "width"}},
+		{[]byte("align=right,strict=false"), map[string]interface{}{"align": "right", "width": 0, "fill": " ", "strict": false},
+			[]string{"align", "strict"}},
+		{[]byte(`fill=\,\=\\\x=`), map[string]interface{}{"align": "left", "width": 0, "fill": `,=\\x=`, "strict": false},
+			[]string{"fill"}},
+		{[]byte("fill="), map[string]interface{}{"align": "left", "width": 0, "fill": "", "strict": false},
+			[]string{"fill"}},
+	}
+
+	for _, test := range tests {
+		params, err := testSpec.Parse(test.params)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("for %q, got unexpected error %v", test.params, err))
+		}
+		if !reflect.DeepEqual(params.values, test.values) {
+			t.Fatal(fmt.Sprintf("for %q, expected %#v, got %#v", test.params, test.values, params.values))
+		}
+		for _, param := range testSpec {
+			given := false
+			for _, name := range test.given {
+				given = given || name == param.Name
+			}
+			if params.Given(param.Name) != given {
+				t.Fatal(fmt.Sprintf("for %q, %s has the wrong given", test.params, param.Name))
+			}
+		}
+		if string(params.Raw()) != string(test.params) {
+			t.Fatal("Raw does not return the params")
+		}
+	}
+
+	params, _ := testSpec.Parse([]byte("right,7,strict"))
+	if params.String("align") != "right" || params.Int("width") != 7 ||
+		!params.Bool("strict") || params.String("width") != "" || params.Int("nope") != 0 {
+		t.Fatal("Params accessors are wrong:", params)
+	}
+
+	for params, problem := range map[string]string{
+		"up":             "width must be an integer",
+		"1,2":            `unknown parameter "2"`,
+		"width=x":        "width must be an integer",
+		"align=up":       "align must be one of left, right",
+		"strict=maybe":   "strict must be true or false",
+		"left,right":     "align given more than once",
+		"nope=1":         `unknown parameter "nope"`,
+		"left,,":         `unknown parameter ""`,
+		"width=1,width=": "width given more than once",
+	} {
+		_, err := testSpec.Parse([]byte(params))
+		var ua ErrUnknownArguments
+		if !errors.As(err, &ua) || string(ua.Arguments) != params ||
+			ua.ErrorStr != problem+"; allowed parameters are "+
+				"align=left|right, width=<integer>, fill=<string>, strict" {
+			t.Fatal(fmt.Sprintf("for %s, got the wrong error: %v", params, err))
+		}
+	}
+
+	_, err := ParamSpec{}.Parse([]byte("x"))
+	if err == nil || !strings.Contains(err.Error(), "no parameters are allowed") {
+		t.Fatal("empty specs do not reject parameters:", err)
+	}
+}
+
+func TestParamSpecValidate(t *testing.T) {
+	for _, spec := range []ParamSpec{
+		{{Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Type: BoolParam, Positional: true}},
+		{{Name: "a", Type: EnumParam}},
+		{{Name: "a", Type: BoolParam}, {Name: "b", Type: EnumParam, Values: []string{"a"}}},
+		{{Name: "a", Type: EnumParam, Values: []string{"x"}}, {Name: "b", Type: EnumParam, Values: []string{"x"}}},
+		{{Name: "a", Type: IntParam, Default: "x"}},
+		{{Name: "a", Type: EnumParam, Values: []string{"x"}, Default: "y"}},
+		{{Name: "a", Type: ParamType(17)}},
+	} {
+		i := NewInterpolator()
+		err := i.AddParamEncoder("enc", spec, nil)
+		if _, isBadSpec := err.(errBadParamSpec); !isBadSpec {
+			t.Fatal(fmt.Sprintf("for %#v, expected a bad spec error, got %v", spec, err))
+		}
+	}
+}
+
+func TestParamHandlers(t *testing.T) {
+	i := NewDefaultInterpolator()
+	calls := 0
+	err := i.AddParamEncoder("repeat", testSpec, func(w io.Writer, params Params) (io.Writer, error) {
+		calls++
+		return WriterFunc(func(b []byte) (int, error) {
+			for n := 0; n < params.Int("width"); n++ {
+				_, err := w.Write(b)
+				if err != nil {
+					return 0, err
+				}
+			}
+			return len(b), nil
+		}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = i.AddParamFormatter("quote", ParamSpec{{Name: "with", Default: `"`}},
+		func(w io.Writer, val interface{}, params Params) error {
+			_, err := fmt.Fprintf(w, "%s%v%s", params.String("with"), val, params.String("with"))
+			return err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.AddParamEncoder("cdata", nil, nil) != errAlreadyExists("cdata") ||
+		i.AddEncoder("repeat", raw) != errAlreadyExists("repeat") ||
+		i.AddFormatter("quote", JSON) != errAlreadyExists("quote") {
+		t.Fatal("param handlers do not share the namespace")
+	}
+
+	tmpl := i.MustCompile("%RAW|repeat:3;%quote;%quote:with=';")
+	for n := 0; n < 2; n++ {
+		res, err := tmpl.String("ab", 1, 2)
+		if err != nil || res != `ababab"1"'2'` {
+			t.Fatal("param handlers failed:", res, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatal("the encoder should be invoked once per execution, got", calls)
+	}
+
+	res, err := i.InterpStr("%json:indent=2;", []int{1})
+	if err != nil || res != "[\n  1\n]\n" {
+		t.Fatal("json indent does not work:", res, err)
+	}
+
+	// bad parameters are caught when compiling, before anything is written
+	for format, offset := range map[string]int{
+		"abc%base64:bogus;":    4,
+		"abc%RAW|repeat:x,y;":  8,
+		"abc%quote:nope;":      4,
+		"abc%cdata:nocrlf=x;":  4,
+		"abc%json:indent=two;": 4,
+	} {
+		w := &recordingWriter{}
+		err = i.InterpWriter(w, []byte(format), "x")
+		var pe *ParseError
+		var ua ErrUnknownArguments
+		if !errors.As(err, &pe) || pe.Offset != offset || !errors.As(err, &ua) ||
+			w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, expected a parse error at %d, got %v", format, offset, err))
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	i := NewDefaultInterpolator()
+	calls := 0
+	err := i.AddValidatedEncoder("b64", Base64, base64Spec.Validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = i.AddValidatedFormatter("form", badFormatter, func(params []byte) error {
+		calls++
+		if params != nil {
+			return ErrCustom
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.AddValidatedEncoder("b64", raw, nil) != errAlreadyExists("b64") {
+		t.Fatal("validated encoders can be registered twice")
+	}
+
+	for _, format := range []string{"%base64:url;", "%RAW|b64:std;", "a%form;b", "%json:indent=1;"} {
+		err = i.Validate(format)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("for %s, got unexpected error %v", format, err))
+		}
+	}
+	if calls != 1 {
+		t.Fatal("the validator was not called")
+	}
+
+	for format, cause := range map[string]error{
+		"abc%base64:bogus;": ErrUnknownArguments{},
+		"abc%RAW|b64:x;":    ErrUnknownArguments{},
+		"abc%form:x;":       ErrCustom,
+		"abc%nope;":         errUnknownFormatter("nope"),
+		"abc%RAW":           errIncompleteFormatString,
+	} {
+		err = i.Validate(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || reflect.TypeOf(pe.Err) != reflect.TypeOf(cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %T, got %v", format, cause, err))
+		}
+
+		w := &recordingWriter{}
+		err = i.InterpWriter(w, []byte(format), "x")
+		if !errors.As(err, &pe) || w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, InterpWriter wrote %q before failing with %v", format, w.String(), err))
+		}
+	}
+}
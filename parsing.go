@@ -1,15 +1,180 @@
 package strinterp
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 )
 
 // This file contains misc. details related to parsing the formatting
 // parameters, etc.
+//
+// The format string is tokenized in a single pass, according to the
+// Syntax of the Interpolator. Escaping is honored independently at each
+// level: in literal text the escape byte escapes anything, but within a
+// directive it only escapes the bytes that mean something to a directive
+// (the first bytes of the delimiters, and the escape byte itself). An
+// escape byte in front of anything else is passed through untouched to
+// the formatter/encoder parameters, so that they can implement their own
+// escaping on top of ours without requiring the user to double up on
+// backslashes.
+
+// When parsing a format string from an io.Reader, literal text is
+// returned in chunks of at most maxLiteralChunk bytes, and directives may
+// not be longer than maxDirectiveLength bytes, so that memory use is
+// bounded no matter how large the format string is.
+const (
+	maxLiteralChunk    = 4096
+	maxDirectiveLength = 65536
+)
+
+// parser produces the Nodes of a format string one at a time.
+type parser struct {
+	src source
+	syn *Syntax
+	// the number of bytes read from src so far
+	offset int
+	// the limits on the size of literals and directives, 0 if unlimited
+	maxLiteral   int
+	maxDirective int
+	// a read error encountered while reading literal text, held until
+	// the literal text read before it has been returned
+	err error
+
+	// set when the literal text has been read up to an open delimiter,
+	// and a directive is to be read next
+	atDirective bool
+	// set when the directive to be read next starts with a trim marker
+	trimOpen bool
+	// set when the last directive ended with a trim marker, so the
+	// whitespace at the start of the following literal text is dropped
+	trimNext bool
+	// whitespace from the end of the last chunk of literal text, held
+	// back in case it needs to be trimmed
+	pending []byte
+	// the number of directives returned so far
+	index int
+}
+
+// directivePos records where a Directive came from, for error reporting.
+type directivePos struct {
+	offset int
+	index  int
+	raw    string
+	// the offset into raw of each stage of the directive
+	stages []int
+}
+
+func newParser(format []byte, syn *Syntax) *parser {
+	return &parser{src: &sliceSource{format}, syn: syn}
+}
+
+func newStreamParser(format io.Reader, syn *Syntax) *parser {
+	return &parser{
+		src:          bufio.NewReader(format),
+		syn:          syn,
+		maxLiteral:   maxLiteralChunk,
+		maxDirective: maxDirectiveLength,
+	}
+}
+
+// A source is what a parser reads the format string from: a
+// *bufio.Reader when streaming, or a sliceSource when the format string
+// is already in memory, which saves copying it into a buffer.
+type source interface {
+	ReadByte() (byte, error)
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+}
+
+// A sliceSource is a source reading from a []byte, with the same
+// semantics as a *bufio.Reader.
+type sliceSource struct {
+	b []byte
+}
+
+func (ss *sliceSource) ReadByte() (byte, error) {
+	if len(ss.b) == 0 {
+		return 0, io.EOF
+	}
+	b := ss.b[0]
+	ss.b = ss.b[1:]
+	return b, nil
+}
+
+func (ss *sliceSource) Peek(n int) ([]byte, error) {
+	if n > len(ss.b) {
+		return ss.b, io.EOF
+	}
+	return ss.b[:n], nil
+}
+
+func (ss *sliceSource) Discard(n int) (int, error) {
+	if n > len(ss.b) {
+		n = len(ss.b)
+		ss.b = nil
+		return n, io.EOF
+	}
+	ss.b = ss.b[n:]
+	return n, nil
+}
+
+func (p *parser) readByte() (byte, error) {
+	b, err := p.src.ReadByte()
+	if err == nil {
+		p.offset++
+	}
+	return b, err
+}
+
+// matchRest checks whether the bytes following a just-read first byte of
+// delim complete it, and if so, consumes them, appending them to raw if
+// it is not nil.
+func (p *parser) matchRest(delim string, raw *[]byte) (bool, error) {
+	rest := delim[1:]
+	if rest == "" {
+		return true, nil
+	}
+	peeked, err := p.src.Peek(len(rest))
+	if string(peeked) != rest {
+		if err == io.EOF {
+			err = nil
+		}
+		return false, err
+	}
+	_, _ = p.src.Discard(len(rest))
+	p.offset += len(rest)
+	if raw != nil {
+		*raw = append(*raw, rest...)
+	}
+	return true, nil
+}
+
+// next returns the next Node of the format string, or io.EOF if there are
+// no more. For a *Directive, it also returns where it came from.
+func (p *parser) next() (Node, *directivePos, error) {
+	if !p.atDirective {
+		if p.err != nil {
+			return nil, nil, p.err
+		}
+		literal, foundDelim, err := p.readLiteral()
+		p.atDirective = foundDelim
+		p.err = err
+		if len(literal) > 0 {
+			return Literal(literal), nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	p.atDirective = false
+
+	return p.readDirective()
+}
 
-// like bytes.ReadBytes, except it goes until the target byte is found not
-// preceded by a \, and eliminates the backslashes out of the result.
+// readLiteral reads literal text up to the next unescaped open delimiter,
+// which it consumes but does not return. If it stops early because of the
+// limit on literal size, it returns false.
 //
 // This is basically the simplest possible correct form of backslash
 // escaping. If it seems like overkill, bear in mind it is very simple and
@@ -20,58 +185,433 @@ import (
 // just so we don't end up with a corner case where a single backslash
 // survives.
 //
-// This does not return the delimiter.
-func readBytesUntilUnescDelim(buf *bytes.Buffer, delim byte) ([]byte, error) {
-	result := []byte{}
+// Unescaped whitespace next to a trim marker is dropped here. Since we
+// can't know whether a trim marker follows until we get there, trailing
+// whitespace is held back rather than returned when the size limit is
+// hit. A run of whitespace is only held back up to the limit on the size
+// of directives, past which only its end is trimmed.
+func (p *parser) readLiteral() ([]byte, bool, error) {
+	result := append([]byte{}, p.pending...)
+	// the number of bytes of unescaped whitespace at the end of result
+	spaces := len(p.pending)
+	p.pending = nil
 
 	for {
-		b, err := buf.ReadByte()
+		if p.maxLiteral > 0 && len(result) >= p.maxLiteral {
+			if spaces < len(result) {
+				p.pending = result[len(result)-spaces:]
+				return result[:len(result)-spaces], false, nil
+			}
+			if len(result) >= p.maxDirective {
+				return result, false, nil
+			}
+		}
+
+		b, err := p.readByte()
 		if err != nil {
-			return result, err
+			return result, false, err
 		}
 
-		if b == 92 { // the backslash tells us to blindly read in the next byte
-			b, err = buf.ReadByte()
+		if p.trimNext && isSpace(b) {
+			continue
+		}
+		p.trimNext = false
+
+		if b == p.syn.Escape { // the backslash tells us to blindly read in the next byte
+			b, err = p.readByte()
 			if err != nil {
-				return result, err
+				return result, false, err
 			}
 			result = append(result, b)
-		} else if b == delim {
-			return result, nil
+			spaces = 0
+			continue
+		}
+		if b == p.syn.Open[0] {
+			isOpen, err := p.matchRest(p.syn.Open, nil)
+			if err != nil {
+				return result, false, err
+			}
+			if isOpen {
+				next, _ := p.src.Peek(2)
+				if len(next) == 2 && next[0] == '-' && isSpace(next[1]) {
+					p.trimOpen = true
+					result = result[:len(result)-spaces]
+				}
+				return result, true, nil
+			}
+		}
+		if isSpace(b) {
+			spaces++
 		} else {
-			result = append(result, b)
+			spaces = 0
 		}
+		result = append(result, b)
 	}
 }
 
-// This function works like "split" but honors escaping.
-func splitHonoringEscaping(buf *bytes.Buffer, delim byte) [][]byte {
-	result := [][]byte{}
+// escapesDash returns whether an escape byte followed by a dash, which
+// have just been appended to raw, escape the dash rather than being
+// passed through. This is only the case at the very start and end of a
+// directive, where the dash could otherwise be taken for a trim marker.
+func (p *parser) escapesDash(raw []byte) bool {
+	if len(raw) == len(p.syn.Open)+2 {
+		return true
+	}
+	next, _ := p.src.Peek(len(p.syn.Close))
+	return string(next) == p.syn.Close
+}
 
-	var err error
+// readDirective reads the rest of a directive whose open delimiter has
+// already been consumed, splitting it into stages, and each stage into its
+// name and parameters, as it goes.
+func (p *parser) readDirective() (Node, *directivePos, error) {
+	syn := p.syn
+	pos := &directivePos{offset: p.offset - len(syn.Open), index: p.index}
+	raw := []byte(syn.Open)
+	d := &Directive{}
 
-	for err == nil {
-		var b []byte
-		b, err = readBytesUntilUnescDelim(buf, delim)
-		if err == nil || err == io.EOF {
-			result = append(result, b)
+	if p.trimOpen {
+		p.trimOpen = false
+		for {
+			b, _ := p.readByte()
+			raw = append(raw, b)
+			next, _ := p.src.Peek(1)
+			if len(next) == 0 || !isSpace(next[0]) {
+				break
+			}
+		}
+	}
+
+	var stage Stage
+	inParams := false
+	pos.stages = append(pos.stages, len(raw))
+	current := []byte{}
+	// whether an explicit index, named argument or comment may still
+	// appear
+	atStart := true
+	// the number of bytes of unescaped whitespace at the end of current
+	spaces := 0
+	// if current ends in a trim marker, its length
+	marker := 0
+
+	add := func(b byte, escaped bool) {
+		space := !escaped && isSpace(b)
+		if space && syn.TrimSpace && len(current) == 0 {
+			if !inParams {
+				pos.stages[len(pos.stages)-1] = len(raw)
+			}
+			return
+		}
+		marker = 0
+		if !escaped && b == '-' && spaces > 0 {
+			marker = spaces + 1
+		}
+		if space {
+			spaces++
+		} else {
+			spaces = 0
+		}
+		atStart = false
+		current = append(current, b)
+	}
+	take := func() []byte {
+		taken := current
+		if syn.TrimSpace {
+			taken = current[:len(current)-spaces]
+		}
+		current = []byte{}
+		spaces = 0
+		marker = 0
+		return taken
+	}
+	endStage := func() {
+		if inParams {
+			stage.Params = take()
+		} else {
+			stage.Name = string(take())
+		}
+		d.Stages = append(d.Stages, stage)
+		stage = Stage{}
+		inParams = false
+	}
+	incomplete := func() (Node, *directivePos, error) {
+		return nil, nil, &ParseError{pos.offset, pos.index, string(raw),
+			caretSnippet(string(raw), 0), errIncomplete(syn.Close)}
+	}
+
+	for {
+		if p.checkLength(raw) != nil {
+			return nil, nil, p.tooLong(pos, raw)
+		}
+
+		b, err := p.readByte()
+		if err == nil {
+			raw = append(raw, b)
+		}
+		if b == syn.Escape && err == nil {
+			b, err = p.readByte()
+			if err == nil {
+				raw = append(raw, b)
+				if !syn.unescapes(b, inParams) && !(b == '-' && p.escapesDash(raw)) {
+					add(syn.Escape, true)
+				}
+				add(b, true)
+				continue
+			}
+		}
+		if err == io.EOF {
+			return incomplete()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		isDelim := func(delim string) bool {
+			if b != delim[0] || err != nil {
+				return false
+			}
+			var matched bool
+			matched, err = p.matchRest(delim, &raw)
+			return matched
+		}
+
+		switch {
+		case b == '#' && atStart:
+			var comment []byte
+			comment, err = p.readComment(&raw)
+			if err == io.EOF {
+				return incomplete()
+			}
+			if _, isTooLong := err.(errTooLong); isTooLong {
+				return nil, nil, p.tooLong(pos, raw)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			return Comment(comment), nil, nil
+		case b == '[' && atStart:
+			d.Index, err = p.readArgIndex(&raw)
+			if err == io.EOF {
+				return incomplete()
+			}
+			if err != nil {
+				at := len(raw) - 1
+				return nil, nil, &ParseError{pos.offset + at, pos.index, string(raw),
+					caretSnippet(string(raw), at), err}
+			}
+			atStart = false
+			pos.stages[0] = len(raw)
+		case b == '@' && atStart:
+			at := len(raw) - 1
+			d.Name, err = p.readArgName(&raw)
+			if err == io.EOF {
+				return incomplete()
+			}
+			if _, isTooLong := err.(errTooLong); isTooLong {
+				return nil, nil, p.tooLong(pos, raw)
+			}
+			if err != nil {
+				return nil, nil, &ParseError{pos.offset + at, pos.index, string(raw),
+					caretSnippet(string(raw), at), err}
+			}
+			atStart = false
+			pos.stages[0] = len(raw)
+		case isDelim(syn.Close):
+			if marker > 0 {
+				current = current[:len(current)-marker]
+				spaces = 0
+				p.trimNext = true
+			}
+			endStage()
+			pos.raw = string(raw)
+
+			// implement the special % escaper, which can't have an
+			// argument to go with it
+			if len(d.Stages) == 1 && d.Stages[0].Name == syn.Open &&
+				d.Stages[0].Params == nil {
+				if d.Index != 0 || d.Name != "" {
+					at := pos.stages[0]
+					return nil, nil, &ParseError{pos.offset + at, pos.index, pos.raw,
+						caretSnippet(pos.raw, at), errEscapeArg}
+				}
+				return PercentEscape{}, nil, nil
+			}
+
+			p.index++
+			return d, pos, nil
+		case isDelim(syn.Pipe):
+			endStage()
+			pos.stages = append(pos.stages, len(raw))
+		case !inParams && isDelim(syn.Params):
+			stage.Name = string(take())
+			inParams = true
+			atStart = false
+		default:
+			if err != nil {
+				break
+			}
+			add(b, false)
+		}
+		if err == io.EOF {
+			return incomplete()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// checkLength returns an errTooLong if a directive has reached the
+// length limit.
+func (p *parser) checkLength(raw []byte) error {
+	if p.maxDirective > 0 && len(raw) >= p.maxDirective {
+		return errTooLong(p.syn.Close)
+	}
+	return nil
+}
+
+// tooLong reports a directive that has reached the length limit.
+func (p *parser) tooLong(pos *directivePos, raw []byte) error {
+	// there's no point in reporting the whole thing
+	head := string(raw[:20]) + "..."
+	return &ParseError{pos.offset, pos.index, head,
+		caretSnippet(head, 0), errTooLong(p.syn.Close)}
+}
+
+// readComment reads the rest of a comment, such as the " note;" of
+// "%# note;", appending what it reads to raw. Within a comment, the escape
+// byte escapes anything, as in literal text.
+func (p *parser) readComment(raw *[]byte) ([]byte, error) {
+	syn := p.syn
+	comment := []byte{}
+	spaces, marker := 0, 0
+	for {
+		err := p.checkLength(*raw)
+		if err != nil {
+			return nil, err
 		}
+		b, err := p.readByte()
+		if err != nil {
+			return nil, err
+		}
+		*raw = append(*raw, b)
+
+		escaped := b == syn.Escape
+		if escaped {
+			b, err = p.readByte()
+			if err != nil {
+				return nil, err
+			}
+			*raw = append(*raw, b)
+		} else if b == syn.Close[0] {
+			isClose, err := p.matchRest(syn.Close, raw)
+			if err != nil {
+				return nil, err
+			}
+			if isClose {
+				if marker > 0 {
+					comment = comment[:len(comment)-marker]
+					p.trimNext = true
+				}
+				return comment, nil
+			}
+		}
+
+		marker = 0
+		if !escaped && b == '-' && spaces > 0 {
+			marker = spaces + 1
+		}
+		if !escaped && isSpace(b) {
+			spaces++
+		} else {
+			spaces = 0
+		}
+		comment = append(comment, b)
+	}
+}
+
+// readArgIndex reads the rest of an explicit argument index, such as the
+// "2]" of "%[2]RAW;", appending what it reads to raw.
+func (p *parser) readArgIndex(raw *[]byte) (int, error) {
+	index := 0
+	for digits := 0; ; digits++ {
+		b, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+		*raw = append(*raw, b)
+
+		if b == ']' && digits > 0 {
+			break
+		}
+		// nine digits is plenty, and can't overflow an int
+		if b < '0' || b > '9' || digits == 9 {
+			return 0, errBadArgIndex
+		}
+		index = index*10 + int(b-'0')
 	}
 
-	return result
+	if index == 0 {
+		return 0, errBadArgIndex
+	}
+	return index, nil
 }
 
-func (i *Interpolator) parseEncoder(formatSpec []byte) (Encoder, []byte, error) {
-	formatChunks := bytes.SplitN(formatSpec, []byte(":"), 2)
-	format := string(formatChunks[0])
-	var formatArgs []byte
-	if len(formatChunks) > 1 {
-		formatArgs = formatChunks[1]
+// readArgName reads the rest of a named argument reference, such as the
+// "user.Name|" of "%@user.Name|cdata;", appending what it reads to raw.
+//
+// A named argument must be followed by a pipeline, so unlike the rest of
+// the directive, the pipe is required and consumed.
+func (p *parser) readArgName(raw *[]byte) (string, error) {
+	syn := p.syn
+	name := []byte{}
+	for {
+		err := p.checkLength(*raw)
+		if err != nil {
+			return "", err
+		}
+		b, err := p.readByte()
+		if err != nil {
+			return "", err
+		}
+		*raw = append(*raw, b)
+
+		if b == syn.Escape {
+			return "", errBadArgName
+		}
+		for _, delim := range []string{syn.Pipe, syn.Close} {
+			if b != delim[0] {
+				continue
+			}
+			matched, err := p.matchRest(delim, raw)
+			if err != nil {
+				return "", err
+			}
+			if !matched {
+				continue
+			}
+			if delim == syn.Close {
+				return "", errNoPipeline
+			}
+			if syn.TrimSpace {
+				name = bytes.TrimRight(name, " \t\r\n")
+			}
+			if !validArgName(name) {
+				return "", errBadArgName
+			}
+			return string(name), nil
+		}
+		name = append(name, b)
 	}
+}
 
-	encoder := i.encoders[format]
-	if encoder == nil {
-		return nil, nil, errUnknownEncoder(format)
+// validArgName checks that a name is a dotted path with no empty
+// components.
+func validArgName(name []byte) bool {
+	for _, component := range bytes.Split(name, []byte(".")) {
+		if len(component) == 0 {
+			return false
+		}
 	}
-	return encoder, formatArgs, nil
+	return true
 }
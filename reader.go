@@ -0,0 +1,103 @@
+package strinterp
+
+import (
+	"io"
+	"sync"
+)
+
+// This file contains the pull-based interface to interpolation, for
+// handing results to things that want to read them rather than be
+// written to.
+
+// NewReader returns an io.ReadCloser that yields the result of
+// interpolating the format string with the given args.
+//
+// The output is produced lazily, as it is read. Any error from compiling
+// the format string, or from the formatters and encoders, is returned from
+// Read. Closing the reader stops the interpolation and closes any args
+// that are io.ReadClosers, so the reader must always be closed, even if it
+// is read to the end.
+func (i *Interpolator) NewReader(format string, args ...interface{}) io.ReadCloser {
+	t, err := i.Compile(format)
+	if err != nil {
+		return &interpReader{args: args, err: err}
+	}
+	return t.NewReader(args...)
+}
+
+// NewReader returns an io.ReadCloser that yields the result of executing
+// the template with the given args. See Interpolator.NewReader.
+func (t *Template) NewReader(args ...interface{}) io.ReadCloser {
+	return &interpReader{t: t, args: args}
+}
+
+// interpReader executes a template in its own goroutine the first time it
+// is read from, feeding the output through an io.Pipe, which naturally
+// makes the goroutine wait until more output is asked for.
+type interpReader struct {
+	t    *Template
+	args []interface{}
+	// if set, the template failed to compile, and this is the error to
+	// return from all reads
+	err error
+
+	start  sync.Once
+	pr     *io.PipeReader
+	done   chan struct{}
+	closed sync.Once
+}
+
+func (ir *interpReader) Read(b []byte) (int, error) {
+	if ir.err != nil {
+		return 0, ir.err
+	}
+
+	ir.start.Do(func() {
+		pr, pw := io.Pipe()
+		ir.pr = pr
+		ir.done = make(chan struct{})
+		go func() {
+			defer close(ir.done)
+			// a nil error closes the pipe with io.EOF
+			_ = pw.CloseWithError(ir.t.Execute(pw, ir.args...))
+		}()
+	})
+
+	if ir.pr == nil {
+		// closed before it was ever read
+		return 0, io.ErrClosedPipe
+	}
+	return ir.pr.Read(b)
+}
+
+// Close stops the interpolation if it is still running, and closes any
+// args that are io.ReadClosers. Once Close returns, the args will not be
+// used again.
+func (ir *interpReader) Close() error {
+	var err error
+	ir.closed.Do(func() {
+		// This prevents a later Read from starting the interpolation, and
+		// if it has already started, tells us how to stop it.
+		ir.start.Do(func() {})
+
+		if ir.pr != nil {
+			// any write the interpolation is blocked on will now fail
+			_ = ir.pr.Close()
+		}
+
+		for _, arg := range ir.args {
+			rc, isReadCloser := arg.(io.ReadCloser)
+			if isReadCloser {
+				cerr := rc.Close()
+				if err == nil {
+					err = cerr
+				}
+			}
+		}
+
+		if ir.pr != nil {
+			<-ir.done
+		}
+	})
+	return err
+}
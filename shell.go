@@ -0,0 +1,90 @@
+package strinterp
+
+import (
+	"io"
+)
+
+// This file contains the POSIX shell encoders.
+
+// Shell defines an Encoder for a single word on a POSIX shell command
+// line, as in "ssh host rm %sh;". The word is written in single quotes,
+// in which nothing is special to the shell but the single quote itself,
+// which is written by ending the quotes, writing it with a backslash, and
+// starting them again. The result is always exactly one word, even when
+// it is empty.
+//
+// As there is no way to pass NUL in a shell word, it is an error. It
+// takes no parameters.
+func Shell(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, shellEncoder)
+}
+
+func shellEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	escaped := &shellEscaper{inner: inner, escapes: shellEscapes}
+	return &quotingWriter{inner: inner, escaped: escaped, quote: []byte("'")}, nil
+}
+
+var shellEscapes = map[byte][]byte{
+	'\'': []byte(`'\''`),
+}
+
+// ShellDQ defines an Encoder for text that is already inside double
+// quotes on a POSIX shell command line, as in `echo "Hello, %shdq;"`.
+// "$", "`", "\" and `"` are escaped with a backslash, so that nothing can
+// be expanded and the quotes can't be ended.
+//
+// Interactive bash also expands "!" in double quotes, and a backslash
+// does not reliably stop it, so "!" is written by ending the double
+// quotes, writing it in single quotes, and starting them again.
+//
+// As with Shell, NUL is an error. It takes no parameters.
+func ShellDQ(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, shellDQEncoder)
+}
+
+func shellDQEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return &shellEscaper{inner: inner, escapes: shellDQEscapes}, nil
+}
+
+var shellDQEscapes = map[byte][]byte{
+	'$':  []byte(`\$`),
+	'`':  []byte("\\`"),
+	'\\': []byte(`\\`),
+	'"':  []byte(`\"`),
+	'!':  []byte(`"'!'"`),
+}
+
+// A shellEscaper replaces the bytes that have escapes, and rejects NUL.
+// Everything the shell treats specially is ASCII, so it works on bytes
+// rather than runes, and passes anything else through untouched.
+type shellEscaper struct {
+	inner   io.Writer
+	escapes map[byte][]byte
+}
+
+func (se *shellEscaper) Write(b []byte) (int, error) {
+	start := 0
+	for idx, c := range b {
+		escape := se.escapes[c]
+		if escape == nil && c != 0 {
+			continue
+		}
+		_, err := se.inner.Write(b[start:idx])
+		if err != nil {
+			return start, err
+		}
+		if c == 0 {
+			return idx, errShellNUL
+		}
+		_, err = se.inner.Write(escape)
+		if err != nil {
+			return idx, err
+		}
+		start = idx + 1
+	}
+	_, err := se.inner.Write(b[start:])
+	if err != nil {
+		return start, err
+	}
+	return len(b), nil
+}
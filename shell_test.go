@@ -0,0 +1,138 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitWords stands in for a POSIX shell splitting a simple command line
+// into words. Anything that the shell would expand, or that would end the
+// command, is an error, so a successful split means the shell would see
+// exactly these words.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word []byte
+	inWord := false
+	for idx := 0; idx < len(line); idx++ {
+		c := line[idx]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = nil, false
+			}
+			continue
+		case c == '\'':
+			end := strings.IndexByte(line[idx+1:], '\'')
+			if end == -1 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word = append(word, line[idx+1:idx+1+end]...)
+			idx += end + 1
+		case c == '"':
+			for idx++; ; idx++ {
+				if idx == len(line) {
+					return nil, errors.New("unterminated double quote")
+				}
+				c = line[idx]
+				if c == '"' {
+					break
+				}
+				if c == '$' || c == '`' || c == '!' {
+					return nil, fmt.Errorf("%q would be expanded in double quotes", c)
+				}
+				if c == '\\' && idx+1 < len(line) && strings.IndexByte("$`\"\\\n", line[idx+1]) != -1 {
+					idx++
+					c = line[idx]
+				}
+				word = append(word, c)
+			}
+		case c == '\\':
+			if idx+1 == len(line) {
+				return nil, errors.New("trailing backslash")
+			}
+			idx++
+			word = append(word, line[idx])
+		case strings.IndexByte("$`!;&|<>()*?[#~={}", c) != -1:
+			return nil, fmt.Errorf("unquoted %q", c)
+		default:
+			word = append(word, c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+var shellTests = []string{
+	"",
+	"plain",
+	"two words",
+	"it's",
+	"'",
+	"''",
+	`'\''`,
+	`"quoted" and \backslashed\`,
+	"$HOME ${PATH} $(rm -rf /) `rm -rf /`",
+	"!! !-1 !rm",
+	"a;b|c&d>e<f",
+	"*?[a-z]~#",
+	"new\nline\ttab",
+	"日本語 é",
+	"\xff\xfe",
+}
+
+func TestShell(t *testing.T) {
+	i := NewDefaultInterpolator()
+	for _, s := range shellTests {
+		for _, format := range []string{"echo %sh;", `echo "%shdq;"`, `echo "a %shdq; b"`} {
+			res, err := i.InterpStr(format, s)
+			if err != nil {
+				t.Fatal(fmt.Sprintf("for %s with %q, got %v", format, s, err))
+			}
+			expected := []string{"echo", s}
+			if strings.Contains(format, "a ") {
+				expected[1] = "a " + s + " b"
+			}
+			words, err := splitWords(res)
+			if err != nil || !reflect.DeepEqual(words, expected) {
+				t.Fatal(fmt.Sprintf("for %s with %q, %s splits into %q, %v",
+					format, s, res, words, err))
+			}
+		}
+	}
+
+	for format, expected := range map[string]string{
+		"%sh;":   `'it'\''s $x'`,
+		"%shdq;": `it's \$x`,
+	} {
+		res, err := i.InterpStr(format, "it's $x")
+		if err != nil || res != expected {
+			t.Fatal(fmt.Sprintf("for %s, expected %s, got %s, %v", format, expected, res, err))
+		}
+	}
+
+	// quotes are handled however the word is written
+	buf := &recordingWriter{}
+	enc, _ := Shell(buf, nil)
+	for _, chunk := range []string{"a'", "'b", "", "c'"} {
+		_, _ = enc.Write([]byte(chunk))
+	}
+	_ = enc.(*quotingWriter).Close()
+	words, err := splitWords(buf.String())
+	if err != nil || !reflect.DeepEqual(words, []string{"a''bc'"}) {
+		t.Fatal("sh does not quote correctly across writes:", buf.String(), err)
+	}
+
+	for _, format := range []string{"%sh;", "%shdq;"} {
+		_, err := i.InterpStr(format, "rm\x00 -rf")
+		if !errors.Is(err, errShellNUL) {
+			t.Fatal(fmt.Sprintf("for %s, NUL is allowed: %v", format, err))
+		}
+	}
+}
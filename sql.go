@@ -0,0 +1,194 @@
+package strinterp
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// This file contains SQL mode, in which the arg and args directives write
+// placeholders and collect their values, rather than writing them into
+// the query.
+
+// A SQLDialect determines how the placeholders for query arguments are
+// written in SQL mode.
+type SQLDialect int
+
+const (
+	noSQL SQLDialect = iota
+	// MySQL writes "?" placeholders, as do SQLite and most other
+	// databases.
+	MySQL
+	// SQLite writes "?" placeholders.
+	SQLite
+	// Postgres writes numbered placeholders: "$1", "$2", and so on.
+	Postgres
+	// SQLServer writes numbered placeholders: "@p1", "@p2", and so on.
+	SQLServer
+	// Oracle writes named placeholders: ":p1", ":p2", and so on. Their
+	// values are returned as sql.NamedArgs with the matching names.
+	Oracle
+)
+
+// sqlKeywords are the names that are keywords in SQL mode.
+var sqlKeywords = map[string]bool{
+	"arg":  true,
+	"args": true,
+}
+
+// SetSQLDialect puts the Interpolator in SQL mode, writing placeholders
+// for the given SQLDialect.
+//
+// In SQL mode, "%arg;" does not write its arg into the output at all.
+// Instead, it writes a placeholder, and its arg is added to the list of
+// query arguments returned by InterpSQL or Template.ExecuteSQL, ready to
+// be passed to database/sql:
+//
+//	query, args, err := i.InterpSQL(
+//	    "SELECT * FROM t WHERE id = %arg; AND tag IN (%args;)", id, tags)
+//	rows, err := db.Query(query, args...)
+//
+// "%args;" takes a slice, array, channel or iterator function, as each
+// does, and writes a comma-separated placeholder for each of its
+// elements, for IN lists. As "IN ()" is not valid SQL, it is an error for
+// it to be empty. A []byte or a string is a single value, so it is an
+// error to give one to "%args;"; use "%arg;" for it.
+//
+// Neither can have a pipeline. The rest of the format string still works
+// as usual, so identifiers can be written with the sqlident encoder, but
+// as a query's values should always be arguments, nothing that writes
+// them into the query is provided.
+//
+// "arg" and "args" are reserved once the Interpolator is in SQL mode, so
+// if either is already used by a formatter or encoder, an error will be
+// returned.
+func (i *Interpolator) SetSQLDialect(dialect SQLDialect) error {
+	if dialect <= noSQL || dialect > Oracle {
+		return errUnknownSQLDialect
+	}
+	for name := range sqlKeywords {
+		if i.registered(name) {
+			return errAlreadyExists(name)
+		}
+	}
+	i.dialect = dialect
+	return nil
+}
+
+// NewSQLInterpolator returns a new Interpolator in SQL mode for the given
+// SQLDialect, with the same primitives as NewInterpolator, along with
+// the SQLIdent encoder as "sqlident". See SetSQLDialect.
+func NewSQLInterpolator(dialect SQLDialect) (*Interpolator, error) {
+	i := NewInterpolator()
+	err := i.SetSQLDialect(dialect)
+	if err != nil {
+		return nil, err
+	}
+	i.paramEncoders["sqlident"] = paramEncoder{sqlIdentSpec, i.sqlIdentEncoder}
+	i.validators["sqlident"] = sqlIdentSpec.validator(i.checkSQLIdent)
+	return i, nil
+}
+
+// InterpSQL interpolates a query in SQL mode, returning the query along
+// with the arguments for its placeholders. See SetSQLDialect.
+func (i *Interpolator) InterpSQL(format string, args ...interface{}) (string, []interface{}, error) {
+	t, err := i.Compile(format)
+	if err != nil {
+		return "", nil, err
+	}
+	return t.ExecuteSQL(args...)
+}
+
+// ExecuteSQL executes a template compiled in SQL mode, returning the
+// query along with the arguments for its placeholders. See
+// Interpolator.SetSQLDialect.
+func (t *Template) ExecuteSQL(args ...interface{}) (string, []interface{}, error) {
+	if t.dialect == noSQL {
+		return "", nil, errNotSQLMode
+	}
+	buf := new(bytes.Buffer)
+	query := &sqlQuery{dialect: t.dialect}
+	err := t.execute(&execution{i: t.i, w: buf, args: args, strict: t.strict, sql: query})
+	if err != nil {
+		return "", nil, err
+	}
+	return buf.String(), query.args, nil
+}
+
+// compileSQLArg resolves an arg or args directive.
+func (i *Interpolator) compileSQLArg(n *Directive) (*directive, int, error) {
+	_, err := ParamSpec{}.Parse(n.Stages[0].Params)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(n.Stages) > 1 {
+		return nil, 1, errSQLArgPipeline
+	}
+	return &directive{sqlArg: true, sqlList: n.Stages[0].Name == "args"}, 0, nil
+}
+
+// A sqlQuery collects the arguments for the placeholders written into a
+// query.
+type sqlQuery struct {
+	dialect SQLDialect
+	args    []interface{}
+}
+
+// placeholder executes an arg or args directive.
+func (e *execution) placeholder(d *directive, arg interface{}) error {
+	if e.sql == nil {
+		return errNotSQLMode
+	}
+	if !d.sqlList {
+		return e.sql.add(e.w, arg)
+	}
+	// database/sql takes a []byte as a single value, so it would be a
+	// surprise for it to be taken apart here
+	if t := reflect.TypeOf(arg); t != nil && t.Kind() == reflect.Slice &&
+		t.Elem().Kind() == reflect.Uint8 {
+		return errBytesSQLList
+	}
+
+	first := true
+	err := iterate(arg, func(elem interface{}) error {
+		if !first {
+			_, err := e.w.Write([]byte(", "))
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		return e.sql.add(e.w, elem)
+	})
+	if err == nil && first {
+		return errEmptySQLList
+	}
+	return err
+}
+
+// add adds an argument to the query, and writes its placeholder.
+func (q *sqlQuery) add(w io.Writer, arg interface{}) error {
+	if _, isNotGiven := arg.(NotGivenType); isNotGiven {
+		return ErrNotGiven
+	}
+
+	n := strconv.Itoa(len(q.args) + 1)
+	var placeholder string
+	switch q.dialect {
+	case Postgres:
+		placeholder = "$" + n
+	case SQLServer:
+		placeholder = "@p" + n
+	case Oracle:
+		placeholder = ":p" + n
+		arg = sql.Named("p"+n, arg)
+	default:
+		placeholder = "?"
+	}
+
+	q.args = append(q.args, arg)
+	_, err := io.WriteString(w, placeholder)
+	return err
+}
@@ -0,0 +1,127 @@
+package strinterp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSQL(t *testing.T) {
+	format := "SELECT * FROM t WHERE id = %arg; AND tag IN (%args;) AND x = %arg;"
+	args := []interface{}{7, []string{"a", "b"}, nil}
+	for dialect, expected := range map[SQLDialect]string{
+		MySQL:     "SELECT * FROM t WHERE id = ? AND tag IN (?, ?) AND x = ?",
+		SQLite:    "SELECT * FROM t WHERE id = ? AND tag IN (?, ?) AND x = ?",
+		Postgres:  "SELECT * FROM t WHERE id = $1 AND tag IN ($2, $3) AND x = $4",
+		SQLServer: "SELECT * FROM t WHERE id = @p1 AND tag IN (@p2, @p3) AND x = @p4",
+		Oracle:    "SELECT * FROM t WHERE id = :p1 AND tag IN (:p2, :p3) AND x = :p4",
+	} {
+		i, err := NewSQLInterpolator(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, queryArgs, err := i.InterpSQL(format, args...)
+		if err != nil || query != expected {
+			t.Fatal(fmt.Sprintf("for dialect %d, expected %s, got %s, %v",
+				dialect, expected, query, err))
+		}
+
+		expectedArgs := []interface{}{7, "a", "b", nil}
+		if dialect == Oracle {
+			for idx, arg := range expectedArgs {
+				expectedArgs[idx] = sql.Named(fmt.Sprintf("p%d", idx+1), arg)
+			}
+		}
+		if !reflect.DeepEqual(queryArgs, expectedArgs) {
+			t.Fatal(fmt.Sprintf("for dialect %d, expected args %#v, got %#v",
+				dialect, expectedArgs, queryArgs))
+		}
+	}
+
+	// values never end up in the query, whatever they are
+	i, _ := NewSQLInterpolator(Postgres)
+	_ = i.AddTemplate("byName", "name = %arg;")
+	tmpl := i.MustCompile("SELECT %RAW; FROM t WHERE %[3]include:byName; AND id IN (%[2]args;)")
+	for run := 0; run < 2; run++ {
+		query, queryArgs, err := tmpl.ExecuteSQL("a, b", [2]int{1, 2}, "'; DROP TABLE t; --")
+		if err != nil || query != "SELECT a, b FROM t WHERE name = $1 AND id IN ($2, $3)" ||
+			!reflect.DeepEqual(queryArgs, []interface{}{"'; DROP TABLE t; --", 1, 2}) {
+			t.Fatal("templates don't collect args correctly:", query, queryArgs, err)
+		}
+	}
+
+	query, queryArgs, err := i.InterpSQL("%if;x = %arg;%else;x IS NULL%end;", nil)
+	if err != nil || query != "x IS NULL" || len(queryArgs) != 0 {
+		t.Fatal("skipped placeholders still collect args:", query, queryArgs, err)
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	i, _ := NewSQLInterpolator(MySQL)
+	for _, format := range []string{"%arg|RAW;", "%args|RAW;"} {
+		_, _, err := i.InterpSQL(format, 1)
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, errSQLArgPipeline) {
+			t.Fatal(fmt.Sprintf("for %s, pipelines are allowed: %v", format, err))
+		}
+	}
+	_, _, err := i.InterpSQL("%arg:x;", 1)
+	if _, isParseError := err.(*ParseError); !isParseError {
+		t.Fatal("arg takes parameters:", err)
+	}
+
+	for _, test := range []struct {
+		format string
+		args   []interface{}
+		cause  error
+	}{
+		{"%args;", []interface{}{[]int{}}, errEmptySQLList},
+		{"%args;", []interface{}{1}, errNotIterable},
+		{"x IN (%args;)", []interface{}{[]byte("ab")}, errBytesSQLList},
+		{"x IN (%args;)", []interface{}{json.RawMessage("[1]")}, errBytesSQLList},
+		{"x IN (%args;)", []interface{}{"ab"}, errNotIterable},
+		{"%arg; %arg;", []interface{}{1}, ErrNotGiven},
+		{"%args;", nil, ErrNotGiven},
+	} {
+		_, _, err := i.InterpSQL(test.format, test.args...)
+		if !errors.Is(err, test.cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %v, got %v", test.format, test.cause, err))
+		}
+	}
+
+	// a []byte is one value, but a list of them is still a list
+	query, args, err := i.InterpSQL("%arg; IN (%args;)", []byte("ab"), [][]byte{[]byte("a"), []byte("b")})
+	if err != nil || query != "? IN (?, ?)" ||
+		!reflect.DeepEqual(args, []interface{}{[]byte("ab"), []byte("a"), []byte("b")}) {
+		t.Fatal("[]byte arguments are not single values:", query, args, err)
+	}
+
+	// arg and args are only special in SQL mode
+	_, err = i.InterpStr("%arg;", 1)
+	if !errors.Is(err, errNotSQLMode) {
+		t.Fatal("arg can be used outside of InterpSQL:", err)
+	}
+	_, _, err = NewInterpolator().MustCompile("x").ExecuteSQL()
+	if !errors.Is(err, errNotSQLMode) {
+		t.Fatal("ExecuteSQL can be used without a dialect:", err)
+	}
+	_, err = NewInterpolator().InterpStr("%arg;", 1)
+	if !errors.Is(err, errUnknownFormatter("arg")) {
+		t.Fatal("arg is a keyword outside SQL mode:", err)
+	}
+
+	if i.AddEncoder("args", raw) != errAlreadyExists("args") {
+		t.Fatal("args can be registered in SQL mode")
+	}
+	other := NewInterpolator()
+	_ = other.AddEncoder("arg", raw)
+	if other.SetSQLDialect(Postgres) != errAlreadyExists("arg") {
+		t.Fatal("SQL mode can be set when arg is already registered")
+	}
+	if other.SetSQLDialect(SQLDialect(0)) != errUnknownSQLDialect {
+		t.Fatal("unknown dialects can be set")
+	}
+}
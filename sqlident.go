@@ -0,0 +1,162 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// This file contains the SQL identifier encoder.
+
+var sqlIdentSpec = ParamSpec{
+	{Name: "dialect", Type: EnumParam, Positional: true,
+		Values: []string{"postgres", "mysql", "sqlite", "mssql", "oracle"}},
+	{Name: "allow", Type: StringParam},
+}
+
+// identQuotes are the quotes identifiers are written in for each dialect.
+// The closing quote is escaped by doubling it.
+var identQuotes = map[string][2]byte{
+	"postgres": {'"', '"'},
+	"sqlite":   {'"', '"'},
+	"oracle":   {'"', '"'},
+	"mysql":    {'`', '`'},
+	"mssql":    {'[', ']'},
+}
+
+// dialectNames are the sqlident dialects for the SQLDialects.
+var dialectNames = map[SQLDialect]string{
+	MySQL:     "mysql",
+	SQLite:    "sqlite",
+	Postgres:  "postgres",
+	SQLServer: "mssql",
+	Oracle:    "oracle",
+}
+
+// AddIdentifierSet adds a named set of SQL identifiers to the
+// interpolator, which the sqlident encoder can then be restricted to with
+// "%sqlident:allow=name;".
+//
+// If the name is already used by another set, an error will be returned.
+func (i *Interpolator) AddIdentifierSet(name string, identifiers ...string) error {
+	if _, exists := i.identifierSets[name]; exists {
+		return errIdentifierSetExists(name)
+	}
+	set := map[string]bool{}
+	for _, identifier := range identifiers {
+		set[identifier] = true
+	}
+	i.identifierSets[name] = set
+	return nil
+}
+
+// SQLIdent defines an Encoder for a quoted SQL identifier, such as a
+// column or table name that is chosen at runtime and so can't be a
+// placeholder, as in "ORDER BY %sqlident:postgres;".
+//
+// The identifier is quoted for the dialect given by its positional
+// parameter: "postgres", "sqlite" and "oracle" use double quotes, "mysql"
+// uses backticks, and "mssql" uses square brackets, and the closing quote
+// is escaped by doubling it. An Interpolator in SQL mode uses its own
+// dialect by default. Identifiers that are empty, contain NUL, or are not
+// valid UTF-8 are rejected.
+//
+// The identifier is quoted as a single name, so a qualified name such as
+// a schema and table has to be written as two, as in
+// "%sqlident;.%sqlident;".
+//
+// With "allow=name", the identifier must also be one of the named set of
+// identifiers added to the Interpolator with AddIdentifierSet. As such a
+// set can only be used through an Interpolator, it is an error to give
+// allow when SQLIdent is used directly.
+//
+// Through an Interpolator, the dialect and the set are checked when the
+// format string is compiled, and nothing is written until the identifier
+// has been checked.
+func SQLIdent(inner io.Writer, args []byte) (io.Writer, error) {
+	params, err := sqlIdentSpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return sqlIdentEncoder(inner, params, noSQL, nil)
+}
+
+// sqlIdentEncoder is the sqlident encoder of the Interpolator, which can
+// use its dialect and identifier sets.
+func (i *Interpolator) sqlIdentEncoder(inner io.Writer, params Params) (io.Writer, error) {
+	return sqlIdentEncoder(inner, params, i.dialect, i.identifierSets)
+}
+
+// checkSQLIdent checks the parameters of the Interpolator's sqlident
+// encoder when a format string is compiled, so that a missing dialect or
+// an unknown identifier set is reported before anything is written.
+func (i *Interpolator) checkSQLIdent(params Params) error {
+	_, _, err := sqlIdentParams(params, i.dialect, i.identifierSets)
+	return err
+}
+
+// sqlIdentParams returns the dialect and the allowed identifiers, if any,
+// the parameters ask for.
+func sqlIdentParams(params Params, dialect SQLDialect,
+	sets map[string]map[string]bool) (string, map[string]bool, error) {
+	name := params.String("dialect")
+	if name == "" {
+		name = dialectNames[dialect]
+	}
+	if name == "" {
+		return "", nil, errNoIdentDialect
+	}
+
+	var allowed map[string]bool
+	if params.Given("allow") {
+		allowed = sets[params.String("allow")]
+		if allowed == nil {
+			return "", nil, errUnknownIdentifierSet(params.String("allow"))
+		}
+	}
+	return name, allowed, nil
+}
+
+func sqlIdentEncoder(inner io.Writer, params Params, dialect SQLDialect,
+	sets map[string]map[string]bool) (io.Writer, error) {
+	name, allowed, err := sqlIdentParams(params, dialect, sets)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlIdentWriter{inner: inner, quotes: identQuotes[name], allowed: allowed}, nil
+}
+
+// A sqlIdentWriter holds back the identifier until it is complete, and
+// then writes it quoted if it is acceptable.
+type sqlIdentWriter struct {
+	inner   io.Writer
+	quotes  [2]byte
+	allowed map[string]bool
+	held    []byte
+}
+
+func (siw *sqlIdentWriter) Write(b []byte) (int, error) {
+	siw.held = append(siw.held, b...)
+	return len(b), nil
+}
+
+// Close checks and writes out the identifier.
+func (siw *sqlIdentWriter) Close() error {
+	ident := siw.held
+	switch {
+	case len(ident) == 0:
+		return errEmptySQLIdent
+	case bytes.IndexByte(ident, 0) != -1, !utf8.Valid(ident):
+		return errBadSQLIdent
+	case siw.allowed != nil && !siw.allowed[string(ident)]:
+		return errIdentifierNotAllowed(ident)
+	}
+
+	closeQuote := []byte{siw.quotes[1]}
+	quoted := make([]byte, 0, len(ident)+2)
+	quoted = append(quoted, siw.quotes[0])
+	quoted = append(quoted, bytes.Replace(ident, closeQuote, []byte{siw.quotes[1], siw.quotes[1]}, -1)...)
+	quoted = append(quoted, siw.quotes[1])
+	_, err := siw.inner.Write(quoted)
+	return err
+}
@@ -0,0 +1,116 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSQLIdent(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		format   string
+		arg      string
+		expected string
+	}{
+		{"%sqlident:postgres;", "name", `"name"`},
+		{"%sqlident:dialect=sqlite;", `a"b`, `"a""b"`},
+		{"%sqlident:oracle;", `""`, `""""""`},
+		{"%sqlident:mysql;", "a`b", "`a``b`"},
+		{"%sqlident:mysql;", `a"b'c`, "`a\"b'c`"},
+		{"%sqlident:mssql;", "a]b[c", "[a]]b[c]"},
+		{"%sqlident:postgres;", "日本 語", `"日本 語"`},
+
+		// the quoted identifier can't be ended early
+		{"%sqlident:postgres;", `x"; DROP TABLE t; --`, `"x""; DROP TABLE t; --"`},
+		{"%sqlident:mysql;", "x`; DROP TABLE t; --", "`x``; DROP TABLE t; --`"},
+		{"%sqlident:mssql;", "x]; DROP TABLE t; --", "[x]]; DROP TABLE t; --]"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %s, got %s, %v",
+				test.format, test.arg, test.expected, res, err))
+		}
+	}
+
+	for arg, cause := range map[string]error{
+		"":      errEmptySQLIdent,
+		"a\x00": errBadSQLIdent,
+		"a\xff": errBadSQLIdent,
+	} {
+		w := &recordingWriter{}
+		err := i.InterpWriter(w, []byte("%sqlident:postgres;"), arg)
+		if !errors.Is(err, cause) || w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %q, expected %v, got %q, %v", arg, cause, w.String(), err))
+		}
+	}
+
+	_, err := i.InterpStr("%sqlident;", "name")
+	if !errors.Is(err, errNoIdentDialect) {
+		t.Fatal("sqlident works without a dialect:", err)
+	}
+
+	// in SQL mode, the dialect defaults to the Interpolator's
+	sqlI, _ := NewSQLInterpolator(SQLServer)
+	query, _, err := sqlI.InterpSQL("SELECT * FROM %sqlident; WHERE id = %arg;", "t", 1)
+	if err != nil || query != "SELECT * FROM [t] WHERE id = @p1" {
+		t.Fatal("sqlident doesn't use the SQL mode dialect:", query, err)
+	}
+}
+
+func TestSQLIdentAllow(t *testing.T) {
+	i, _ := NewSQLInterpolator(Postgres)
+	err := i.AddIdentifierSet("sortable", "name", "created_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.AddIdentifierSet("sortable") != errIdentifierSetExists("sortable") {
+		t.Fatal("identifier sets can be added twice")
+	}
+
+	format := "ORDER BY %sqlident:allow=sortable;"
+	for _, column := range []string{"name", "created_at"} {
+		query, _, err := i.InterpSQL(format, column)
+		if err != nil || query != `ORDER BY "`+column+`"` {
+			t.Fatal(fmt.Sprintf("for %s, got %s, %v", column, query, err))
+		}
+	}
+	for _, column := range []string{"password", "Name", "name "} {
+		_, _, err := i.InterpSQL(format, column)
+		if !errors.Is(err, errIdentifierNotAllowed(column)) {
+			t.Fatal(fmt.Sprintf("for %q, expected it to be rejected, got %v", column, err))
+		}
+	}
+
+	_, _, err = i.InterpSQL("%sqlident:allow=missing;", "name")
+	if !errors.Is(err, errUnknownIdentifierSet("missing")) {
+		t.Fatal("unknown identifier sets are allowed:", err)
+	}
+
+	// problems with the parameters are found before anything is written
+	def := NewDefaultInterpolator()
+	for format, cause := range map[string]error{
+		"hello %RAW|sqlident:postgres,allow=nope;": errUnknownIdentifierSet("nope"),
+		"hello %RAW|sqlident;":                     errNoIdentDialect,
+	} {
+		err = def.Validate(format)
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, cause) {
+			t.Fatal(fmt.Sprintf("for %s, expected %v, got %v", format, cause, err))
+		}
+		w := &recordingWriter{}
+		err = def.InterpWriter(w, []byte(format), "name")
+		if !errors.Is(err, cause) || w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, %q was written before the error %v", format, w.String(), err))
+		}
+	}
+	if i.Validate("%sqlident:allow=sortable;") != nil {
+		t.Fatal("sqlident can't be validated in SQL mode")
+	}
+	_, err = SQLIdent(&recordingWriter{}, []byte("postgres,allow=sortable"))
+	if !errors.Is(err, errUnknownIdentifierSet("sortable")) {
+		t.Fatal("SQLIdent can be used directly with an identifier set:", err)
+	}
+}
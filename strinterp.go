@@ -14,8 +14,16 @@ import (
 // desired format string handlers in a single goroutine. Once initialized,
 // the interpolator can be freely used in any number of goroutines.
 type Interpolator struct {
-	formatters map[string]Formatter
-	encoders   map[string]Encoder
+	formatters      map[string]Formatter
+	encoders        map[string]Encoder
+	paramFormatters map[string]paramFormatter
+	paramEncoders   map[string]paramEncoder
+	validators      map[string]ParamValidator
+	templates       map[string]namedTemplate
+	identifierSets  map[string]map[string]bool
+	strict          bool
+	syntax          Syntax
+	dialect         SQLDialect
 }
 
 /*
@@ -45,10 +53,16 @@ type Encoder func(io.Writer, []byte) (io.Writer, error)
 //      (if an io.Reader, io.Copy is used)
 func NewInterpolator() *Interpolator {
 	return &Interpolator{
-		map[string]Formatter{},
-		map[string]Encoder{
+		formatters: map[string]Formatter{},
+		encoders: map[string]Encoder{
 			"RAW": raw,
 		},
+		paramFormatters: map[string]paramFormatter{},
+		paramEncoders:   map[string]paramEncoder{},
+		validators:      map[string]ParamValidator{},
+		templates:       map[string]namedTemplate{},
+		identifierSets:  map[string]map[string]bool{},
+		syntax:          DefaultSyntax,
 	}
 }
 
@@ -58,31 +72,68 @@ func NewInterpolator() *Interpolator {
 //  json: the JSON formatter
 //  base64: the Base64 encoder
 //  cdata: the HTML CDATA encoder
+//  html: the HTML encoder, for text
+//  htmlattr: the HTMLAttr encoder, for attribute values
+//  pad: the Pad encoder
+//  trunc: the Trunc encoder
+//  urlquery, urlpath, urlfragment: the URLQuery, URLPath and URLFragment
+//    encoders, for parts of URLs
+//  url: the URL encoder, for whole URLs
+//  jsstring: the JSString encoder
+//  cssstring, cssident, csscolor, csslength: the CSSString, CSSIdent,
+//    CSSColor and CSSLength encoders
+//  sqlident: the SQLIdent encoder
+//  sh, shdq: the Shell and ShellDQ encoders, for shell command lines
 //
 // More things may be added in future versions of this library. The safest
 // long-term thing to do is to use NewInterpolator and configure it
 // yourself. But this is convenient for demos and such.
 func NewDefaultInterpolator() *Interpolator {
-	return &Interpolator{
-		map[string]Formatter{
-			"json": JSON,
+	i := &Interpolator{
+		formatters: map[string]Formatter{},
+		encoders: map[string]Encoder{
+			"RAW": raw,
+		},
+		paramFormatters: map[string]paramFormatter{
+			"json": {jsonSpec, jsonFormatter},
 		},
-		map[string]Encoder{
-			"RAW":    raw,
-			"cdata":  CDATA,
-			"base64": Base64,
+		paramEncoders: map[string]paramEncoder{
+			"cdata":       {cdataSpec, cdataEncoder},
+			"base64":      {base64Spec, base64Encoder},
+			"pad":         {padSpec, padEncoder},
+			"trunc":       {truncSpec, truncEncoder},
+			"html":        {ParamSpec{}, htmlEncoder},
+			"htmlattr":    {ParamSpec{}, htmlAttrEncoder},
+			"urlquery":    {urlQuerySpec, urlQueryEncoder},
+			"urlpath":     {ParamSpec{}, urlPathEncoder},
+			"urlfragment": {ParamSpec{}, urlFragmentEncoder},
+			"url":         {urlSpec, urlEncoder},
+			"jsstring":    {jsStringSpec, jsStringEncoder},
+			"cssstring":   {ParamSpec{}, cssStringEncoder},
+			"cssident":    {ParamSpec{}, cssIdentEncoder},
+			"csscolor":    {ParamSpec{}, cssColorEncoder},
+			"csslength":   {ParamSpec{}, cssLengthEncoder},
+			"sh":          {ParamSpec{}, shellEncoder},
+			"shdq":        {ParamSpec{}, shellDQEncoder},
 		},
+		validators: map[string]ParamValidator{
+			"pad":   padSpec.validator(checkPad),
+			"trunc": truncSpec.validator(checkTrunc),
+		},
+		templates:      map[string]namedTemplate{},
+		identifierSets: map[string]map[string]bool{},
+		syntax:         DefaultSyntax,
 	}
+	i.paramEncoders["sqlident"] = paramEncoder{sqlIdentSpec, i.sqlIdentEncoder}
+	i.validators["sqlident"] = sqlIdentSpec.validator(i.checkSQLIdent)
+	return i
 }
 
 // AddFormatter adds a interpolation format to the interpolator.
 //
 // If the format string is already registered, an error will be returned.
 func (i *Interpolator) AddFormatter(format string, handler Formatter) error {
-	if i.formatters[format] != nil {
-		return errAlreadyExists(format)
-	}
-	if i.encoders[format] != nil {
+	if i.registered(format) {
 		return errAlreadyExists(format)
 	}
 
@@ -95,10 +146,7 @@ func (i *Interpolator) AddFormatter(format string, handler Formatter) error {
 //
 // If the format string is already registered, an error will be returned.
 func (i *Interpolator) AddEncoder(format string, handler Encoder) error {
-	if i.formatters[format] != nil {
-		return errAlreadyExists(format)
-	}
-	if i.encoders[format] != nil {
+	if i.registered(format) {
 		return errAlreadyExists(format)
 	}
 
@@ -107,6 +155,62 @@ func (i *Interpolator) AddEncoder(format string, handler Encoder) error {
 	return nil
 }
 
+// registered returns whether the name is already used by any formatter or
+// encoder, or is reserved for a keyword.
+func (i *Interpolator) registered(format string) bool {
+	_, isParamFormatter := i.paramFormatters[format]
+	_, isParamEncoder := i.paramEncoders[format]
+	return i.formatters[format] != nil || i.encoders[format] != nil ||
+		isParamFormatter || isParamEncoder || keywords[format] ||
+		(i.dialect != noSQL && sqlKeywords[format])
+}
+
+// keywords are the names that are handled by strinterp itself, rather
+// than by formatters and encoders.
+var keywords = map[string]bool{
+	"each":    true,
+	"begin":   true,
+	"if":      true,
+	"else":    true,
+	"end":     true,
+	"include": true,
+}
+
+// SetStrict sets whether the Interpolator is in strict mode.
+//
+// In strict mode, the args passed to an interpolation must match up
+// exactly with the positional arguments the format string uses. If any
+// are missing, ErrMissingArguments is returned, and if any are left
+// unused, ErrExtraArguments is returned. Except when streaming the format
+// string with InterpReader, this is checked before anything is written.
+func (i *Interpolator) SetStrict(strict bool) {
+	i.strict = strict
+}
+
+// Strict returns a copy of the Interpolator in strict mode, for when only
+// some calls should be strict:
+//
+//    i.Strict().InterpStr("%RAW;", arg)
+//
+// The copy shares its formatters and encoders with the original.
+func (i *Interpolator) Strict() *Interpolator {
+	strict := *i
+	strict.strict = true
+	return &strict
+}
+
+// CheckArity checks that the format string can be compiled, and that
+// nargs is exactly the number of positional arguments it uses, as strict
+// mode would. Since it doesn't have the args themselves, any
+// ErrExtraArguments it returns will not have any Types.
+func (i *Interpolator) CheckArity(format string, nargs int) error {
+	t, err := i.Compile(format)
+	if err != nil {
+		return err
+	}
+	return checkArity(t.used, nargs, nil)
+}
+
 // InterpStr is a convenience function that does interpolation on a format
 // string and returns the resulting string.
 func (i *Interpolator) InterpStr(format string, args ...interface{}) (string, error) {
@@ -119,105 +223,64 @@ func (i *Interpolator) InterpStr(format string, args ...interface{}) (string, er
 }
 
 // InterpWriter interpolates the format []byte into the passed io.Writer.
+//
+// The format is compiled in its entirety before anything is written, so
+// malformed format strings, unknown formatters or encoders, and
+// parameters rejected by their ParamSpecs or ParamValidators are reported
+// without any output being produced. If you are going to use the
+// same format string repeatedly, use Compile instead.
 func (i *Interpolator) InterpWriter(w io.Writer, formatBytes []byte, args ...interface{}) error {
-	buf := bytes.NewBuffer(formatBytes)
-	for {
-		untilDelim, err := readBytesUntilUnescDelim(buf, '%')
-		if err == io.EOF {
-			// FIXME: Real code ought to do something with remaining unused
-			// args, like fmt does
-			_, err = w.Write(untilDelim)
-			return err
-		}
-
-		_, err = w.Write(untilDelim)
-		if err != nil {
-			return err
-		}
+	t, err := i.compile(formatBytes)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, args...)
+}
 
-		rawFormat, err := readBytesUntilUnescDelim(buf, ';')
+// InterpReader interpolates the format read from the passed io.Reader into
+// the passed io.Writer.
+//
+// Unlike InterpWriter, the format is never held in memory all at once.
+// Literal text is written through to the io.Writer as it is read, and
+// each directive is compiled and interpolated as it is encountered. This
+// means that problems with the format string, including explicit argument
+// indexes that are out of range and strict mode failures, will not be
+// discovered until everything before them has already been written.
+// Directives are limited to 64KB in length.
+func (i *Interpolator) InterpReader(w io.Writer, format io.Reader, args ...interface{}) error {
+	p := newStreamParser(format, &i.syntax)
+	c := &compiler{i: i}
+	e := &execution{i: i, w: w, args: args, strict: i.strict}
+	for {
+		node, pos, err := p.next()
 		if err == io.EOF {
-			return errIncompleteFormatString
-		}
-
-		// implement the special % escaper
-		if len(rawFormat) == 1 && rawFormat[0] == '%' {
-			_, err = w.Write([]byte("%"))
-			if err != nil {
-				return err
-			}
-			continue
-		}
-
-		formatSpecs := splitHonoringEscaping(bytes.NewBuffer(rawFormat), '|')
-
-		writer := NewWriterStack(w)
-
-		// if there are encoders in the specification, we construct them
-		// backwards so as to properly modify the underlying writer.
-		for j := len(formatSpecs) - 1; j >= 1; j-- {
-			encoder, formatArgs, err := i.parseEncoder(formatSpecs[j])
+			err = c.finish()
 			if err != nil {
 				return err
 			}
-
-			err = writer.Push(encoder, formatArgs)
-			if err != nil {
-				return err
+			if i.strict {
+				return checkArity(c.used, len(args), args)
 			}
+			return nil
 		}
-
-		thisFormatter := formatSpecs[0]
-		formatChunks := bytes.SplitN(thisFormatter, []byte(":"), 2)
-		format := string(formatChunks[0])
-		var formatArgs []byte
-		if len(formatChunks) > 1 {
-			formatArgs = formatChunks[1]
-		}
-
-		// If the first string specifies a "formatter", then we go ahead
-		// and just pass off the argument to the formatter and we're done
-		// with it. If the first thing specifies an "encoder", then we
-		// convert the argument to something that we can "Write" with
-		// ourselves. If it's neither, well, that's a problem.
-		formatter := i.formatters[format]
-		encoder := i.encoders[format]
-
-		if formatter == nil && encoder == nil {
-			return errUnknownFormatter(format)
-		}
-
-		var thisArg interface{}
-		if len(args) > 0 {
-			thisArg = args[0]
-			args = args[1:]
-		} else {
-			thisArg = NotGiven
+		if err != nil {
+			return err
 		}
 
-		if formatter != nil {
-			err = formatter(writer, thisArg, formatArgs)
-			err2 := writer.Finish()
-			if err != nil {
-				return err
-			}
-			if err2 != nil {
-				return err2
+		switch n := node.(type) {
+		case Literal:
+			err = e.literal([]byte(n))
+		case PercentEscape:
+			err = e.literal([]byte(i.syntax.Open))
+		case *Directive:
+			var d *directive
+			d, err = c.directive(n, pos)
+			if err == nil {
+				err = e.directive(d)
 			}
 		}
-		if encoder != nil {
-			err = writer.Push(encoder, formatArgs)
-			if err != nil {
-				return err
-			}
-			err = i.writeArgument(thisArg, writer)
-			err2 := writer.Finish()
-			if err != nil {
-				return err
-			}
-			if err2 != nil {
-				return err2
-			}
+		if err != nil {
+			return err
 		}
 	}
 }
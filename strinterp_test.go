@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type StrinterpTest struct {
@@ -60,7 +62,7 @@ func TestInterpolator(t *testing.T) {
 		// is indeed closing everything in the correct order; if
 		// writerStack.Close() is reversed, the result gets cut off
 		{"%base64|base64;", []interface{}{"a"}, "WVE9PQ==", nil},
-		{"%base64:bad;", []interface{}{"a"}, "", ErrUnknownArguments{[]byte("bad"), "can only be std or url, to indicate the standard or URL base64 encoding"}},
+		{"%base64:bad;", []interface{}{"a"}, "", ErrUnknownArguments{[]byte("bad"), `unknown parameter "bad"; allowed parameters are encoding=std|url`}},
 
 		// JSON gets a lot of cases here because we have to cover all the
 		// stuff in htmlSafeJSON
@@ -77,8 +79,13 @@ func TestInterpolator(t *testing.T) {
 		{"%cdata;", []interface{}{"<>"}, "&lt;&gt;", nil},
 		{"%cdata;", []interface{}{"aa<bb>cc"}, "aa&lt;bb&gt;cc", nil},
 		{"%cdata;", []interface{}{"\r\n"}, "&#13;&#10;", nil},
+		// entities are text like any other, and must survive a round trip
+		// through a browser
+		{"%cdata;", []interface{}{"AT&T &amp; &lt;b&gt;"}, "AT&amp;T &amp;amp; &amp;lt;b&amp;gt;", nil},
+		// quotes must not be able to end an attribute value
+		{"%cdata;", []interface{}{`" onclick='x'`}, "&quot; onclick=&apos;x&apos;", nil},
 		{"%cdata:nocrlf;", []interface{}{"\r\n"}, "\r\n", nil},
-		{"%cdata:blargh;", []interface{}{"a"}, "", ErrUnknownArguments{[]byte("blargh"), "only nocrlf allowed for CDATA"}},
+		{"%cdata:blargh;", []interface{}{"a"}, "", ErrUnknownArguments{[]byte("blargh"), `unknown parameter "blargh"; allowed parameters are nocrlf`}},
 	}
 
 	i := NewInterpolator()
@@ -92,7 +99,7 @@ func TestInterpolator(t *testing.T) {
 	for _, test := range tests {
 		res, err := i.InterpStr(test.Format, test.Args...)
 
-		if test.Error != nil && !reflect.DeepEqual(test.Error, err) {
+		if test.Error != nil && !reflect.DeepEqual(test.Error, rootCause(err)) {
 			// note in this case we aren't being hypocritcal... having just
 			// established this package's interpolation is actually broken,
 			// don't try to use it to output an error message!
@@ -113,12 +120,12 @@ func TestWriterErrors(t *testing.T) {
 	}
 
 	err = i.InterpWriter(WriterAlwaysEOF{}, []byte("x%RAW;"), "x")
-	if err != io.EOF {
+	if !errors.Is(err, io.EOF) {
 		t.Fatal("Doesn't handle EOF in plain template writing correctly")
 	}
 
 	err = i.InterpWriter(WriterAlwaysEOF{}, []byte("%RAW;"))
-	if err != ErrNotGiven {
+	if !errors.Is(err, ErrNotGiven) {
 		t.Fatal("Didn't handle error on missing params correctly:")
 	}
 
@@ -128,6 +135,80 @@ func TestWriterErrors(t *testing.T) {
 	}
 }
 
+// recordingWriter records the size of the largest write it receives.
+type recordingWriter struct {
+	bytes.Buffer
+	largest int
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if len(b) > rw.largest {
+		rw.largest = len(b)
+	}
+	return rw.Buffer.Write(b)
+}
+
+func TestInterpReader(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	for _, format := range []string{
+		"", "x", `a\%b\\`, "x%%;y%cdata;z", "%RAW|base64;%json;",
+		"%RAW:\\|\\\\;%cdata:nocrlf;",
+	} {
+		expected, err := i.InterpStr(format, "<a>", "<b>")
+		if err != nil {
+			t.Fatal("could not interpolate", format, err)
+		}
+		buf := new(bytes.Buffer)
+		err = i.InterpReader(buf, iotest.OneByteReader(strings.NewReader(format)), "<a>", "<b>")
+		if err != nil || buf.String() != expected {
+			t.Fatal(fmt.Sprintf("for %s, expected '%s', got '%s' (%v)", format, expected, buf.String(), err))
+		}
+	}
+
+	// literal text is passed through in bounded chunks
+	big := strings.Repeat("a", 10000)
+	rw := &recordingWriter{}
+	err := i.InterpReader(rw, strings.NewReader(big+"%RAW;"+big), "b")
+	if err != nil || rw.String() != big+"b"+big || rw.largest > maxLiteralChunk {
+		t.Fatal("InterpReader does not stream literal text correctly", rw.largest, err)
+	}
+
+	// everything before a problem has been written already
+	buf := new(bytes.Buffer)
+	err = i.InterpReader(buf, strings.NewReader("a%cdata;%blargh;"), "<")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 9 || buf.String() != "a&lt;" {
+		t.Fatal("InterpReader does not process directives as encountered", err)
+	}
+
+	buf.Reset()
+	err = i.InterpReader(buf, io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(ErrCustom)))
+	if err != ErrCustom || buf.String() != "abc" {
+		t.Fatal("InterpReader does not handle reader errors correctly", err)
+	}
+
+	err = i.InterpReader(buf, strings.NewReader("%RAW|"+big+big+big+big+big+big+big))
+	if !errors.As(err, &pe) || pe.Err != errDirectiveTooLong {
+		t.Fatal("InterpReader does not limit directive length", err)
+	}
+
+	err = i.InterpReader(buf, strings.NewReader("%@"+big+big+big+big+big+big+big+"|RAW;"))
+	if !errors.As(err, &pe) || pe.Err != errDirectiveTooLong {
+		t.Fatal("InterpReader does not limit argument name length", err)
+	}
+
+	err = i.InterpReader(buf, strings.NewReader("%#"+big+big+big+big+big+big+big+";x"))
+	if !errors.As(err, &pe) || pe.Err != errDirectiveTooLong {
+		t.Fatal("InterpReader does not limit comment length", err)
+	}
+
+	err = i.InterpReader(buf, strings.NewReader("%RAW"))
+	if !errors.As(err, &pe) || pe.Err != errIncompleteFormatString {
+		t.Fatal("InterpReader does not report incomplete directives", err)
+	}
+}
+
 func TestParameters(t *testing.T) {
 	i := NewInterpolator()
 
@@ -145,10 +226,10 @@ func TestParameters(t *testing.T) {
 
 func TestCover(t *testing.T) {
 	// just assert these don't crash
-	errAlreadyExists("x").Error()
-	errUnknownFormatter("x").Error()
-	ErrUnknownArguments{[]byte("a"), "hello"}.Error()
-	errUnknownEncoder("x").Error()
+	_ = errAlreadyExists("x").Error()
+	_ = errUnknownFormatter("x").Error()
+	_ = ErrUnknownArguments{[]byte("a"), "hello"}.Error()
+	_ = errUnknownEncoder("x").Error()
 
 	interp := NewDefaultInterpolator()
 	if !reflect.DeepEqual(interp.AddFormatter("json", JSON), errAlreadyExists("json")) ||
@@ -159,36 +240,98 @@ func TestCover(t *testing.T) {
 	}
 
 	err := interp.InterpWriter(WriterAlwaysEOF{}, []byte("%cdata;"), "<")
-	if err != io.EOF {
+	if !errors.Is(err, io.EOF) {
 		t.Fatal("Got the wrong error from CDATA when stream closed")
 	}
 }
 
-type readBytesTest struct {
+// rootCause strips off all the wrapping of an error.
+func rootCause(err error) error {
+	for {
+		inner := errors.Unwrap(err)
+		if inner == nil {
+			return err
+		}
+		err = inner
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	i := NewInterpolator()
+
+	tests := []struct {
+		format  string
+		err     *ParseError
+		snippet string
+	}{
+		{"x%RAW", &ParseError{1, 0, "%RAW", "", errIncompleteFormatString},
+			"%RAW\n^"},
+		{"x%%;%RAW;%blargh;", &ParseError{10, 1, "%blargh;", "", errUnknownFormatter("blargh")},
+			"%blargh;\n ^"},
+		{"%RAW;x%RAW|RAW|blargh;", &ParseError{15, 1, "%RAW|RAW|blargh;", "", errUnknownEncoder("blargh")},
+			"%RAW|RAW|blargh;\n         ^"},
+		{"%RAW:a\\:b|blargh;", &ParseError{10, 0, "%RAW:a\\:b|blargh;", "", errUnknownEncoder("blargh")},
+			"%RAW:a\\:b|blargh;\n          ^"},
+		{"x%[2]%;", &ParseError{5, 0, "%[2]%;", "", errEscapeArg},
+			"%[2]%;\n    ^"},
+		{"x%@x|%;", &ParseError{5, 0, "%@x|%;", "", errEscapeArg},
+			"%@x|%;\n    ^"},
+	}
+
+	var pe *ParseError
+	for _, test := range tests {
+		_, err := i.InterpStr(test.format)
+		if !errors.As(err, &pe) {
+			t.Fatal(fmt.Sprintf("for %s, expected a *ParseError, got '%v'", test.format, err))
+		}
+		test.err.Snippet = test.snippet
+		if !reflect.DeepEqual(pe, test.err) {
+			t.Fatal(fmt.Sprintf("for %s, expected %#v, got %#v", test.format, test.err, pe))
+		}
+		if !errors.Is(err, test.err.Err) {
+			t.Fatal("ParseError does not unwrap properly")
+		}
+	}
+
+	_, err := i.InterpStr("%RAW;x")
+	var de *DirectiveError
+	if !errors.As(err, &de) || !reflect.DeepEqual(de, &DirectiveError{0, 0, "%RAW;", ErrNotGiven}) {
+		t.Fatal("runtime failures are not reported as DirectiveErrors:", err)
+	}
+	if !errors.Is(err, ErrNotGiven) {
+		t.Fatal("DirectiveError does not unwrap properly")
+	}
+
+	// and don't crash
+	_ = pe.Error()
+	_ = de.Error()
+	if caretSnippet("\tab", 10) != "\tab\n\t  ^" {
+		t.Fatal("caretSnippet does not handle tabs or out of range positions")
+	}
+}
+
+type readLiteralTest struct {
 	input    string
-	delim    string
 	expected string
 	error    error
 }
 
-func TestReadBytesUntilUnesc(t *testing.T) {
-	tests := []readBytesTest{
-		{"abc", "c", "ab", nil},
-		{"abc", "d", "abc", io.EOF},
-		{`a\bc`, "c", "ab", nil},
-		{`a\\bc`, "c", `a\b`, nil},
-		{`abc\`, "d", `abc`, nil},
+func TestReadLiteral(t *testing.T) {
+	tests := []readLiteralTest{
+		{"ab%c", "ab", nil},
+		{"abc", "abc", io.EOF},
+		{`a\b%c`, "ab", nil},
+		{`a\\b%c`, `a\b`, nil},
+		{`a\%b%c`, `a%b`, nil},
+		{`abc\`, `abc`, io.EOF},
 	}
 
 	for _, test := range tests {
-		res, err := readBytesUntilUnescDelim(
-			bytes.NewBuffer([]byte(test.input)),
-			[]byte(test.delim)[0],
-		)
-		if test.error != nil && !reflect.DeepEqual(test.error, err) {
+		res, foundDelim, err := newParser([]byte(test.input), &DefaultSyntax).readLiteral()
+		if !reflect.DeepEqual(test.error, err) || foundDelim != (err == nil) {
 			t.Fatal("Failed: wrong error on " + test.input)
 		}
-		if res != nil && string(res) != test.expected {
+		if string(res) != test.expected {
 			t.Fatal("Failed: wrong result on " + test.input)
 		}
 	}
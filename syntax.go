@@ -0,0 +1,268 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// This file contains the configurable delimiters of format strings.
+
+// A Syntax defines the delimiters used by format strings.
+//
+// Open and Close surround a directive, Pipe separates the stages of its
+// pipeline, and Params separates the name of a stage from its parameters.
+// They may be more than one byte long, but Close, Pipe and Params must
+// all start with different bytes. Escape is the byte used to escape
+// delimiters, both in literal text and within directives.
+//
+// If TrimSpace is set, unescaped whitespace around the names and
+// parameters of stages is ignored, so directives can be spaced out for
+// readability, as in "{{ json | cdata }}".
+//
+// The explicit argument index and named argument syntax, "[2]" and
+// "@name", is the same for all Syntaxes.
+type Syntax struct {
+	Open      string
+	Close     string
+	Pipe      string
+	Params    string
+	Escape    byte
+	TrimSpace bool
+}
+
+// DefaultSyntax is the Syntax Interpolators use unless configured
+// otherwise, as in "%json|base64:url;".
+var DefaultSyntax = Syntax{
+	Open:   "%",
+	Close:  ";",
+	Pipe:   "|",
+	Params: ":",
+	Escape: '\\',
+}
+
+// BraceSyntax is a Syntax that is handy for templating text that is full
+// of % and ;, such as CSS, SQL or shell scripts, as in
+// "{{ json | base64:url }}".
+var BraceSyntax = Syntax{
+	Open:      "{{",
+	Close:     "}}",
+	Pipe:      "|",
+	Params:    ":",
+	Escape:    '\\',
+	TrimSpace: true,
+}
+
+// DollarSyntax is a Syntax in the style of shell variable expansion, as
+// in "${json|base64:url}".
+var DollarSyntax = Syntax{
+	Open:   "${",
+	Close:  "}",
+	Pipe:   "|",
+	Params: ":",
+	Escape: '\\',
+}
+
+// SetSyntax sets the Syntax of the format strings the Interpolator
+// accepts. Like the formatters and encoders, this should be configured
+// before the Interpolator is used.
+//
+// An error is returned if the Syntax is ambiguous.
+func (i *Interpolator) SetSyntax(syntax Syntax) error {
+	err := syntax.validate()
+	if err != nil {
+		return err
+	}
+	i.syntax = syntax
+	return nil
+}
+
+func (s Syntax) validate() error {
+	if s.Open == "" || s.Close == "" || s.Pipe == "" || s.Params == "" {
+		return errBadSyntax("delimiters can not be empty")
+	}
+
+	firsts := []byte{s.Close[0], s.Pipe[0], s.Params[0]}
+	for idx, b := range firsts {
+		if bytes.IndexByte(firsts[idx+1:], b) != -1 {
+			return errBadSyntax("close, pipe and params delimiters must start with different bytes")
+		}
+		if b == '[' || b == '@' || (s.TrimSpace && isSpace(b)) {
+			return errBadSyntax("delimiters can not start with " + strconv.Quote(string(b)))
+		}
+	}
+	if bytes.IndexByte(append(firsts, s.Open[0]), s.Escape) != -1 ||
+		(s.TrimSpace && isSpace(s.Escape)) {
+		return errBadSyntax("the escape byte can not start a delimiter")
+	}
+	return nil
+}
+
+// Parse parses the given format string using this Syntax. See the Parse
+// function.
+func (s Syntax) Parse(format string) ([]Node, error) {
+	err := s.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{}
+	p := newParser([]byte(format), &s)
+	for {
+		node, _, err := p.next()
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// Format turns the given Nodes back into a format string using this
+// Syntax. See the Format function.
+func (s Syntax) Format(nodes []Node) string {
+	buf := new(bytes.Buffer)
+	for _, node := range nodes {
+		s.writeNode(buf, node)
+	}
+	return buf.String()
+}
+
+func (s Syntax) writeNode(buf *bytes.Buffer, node Node) {
+	switch n := node.(type) {
+	case Literal:
+		s.writeEscaped(buf, []byte(n), []byte{s.Open[0]})
+	case PercentEscape:
+		buf.WriteString(s.Open)
+		s.space(buf)
+		buf.WriteString(s.Open)
+		s.space(buf)
+		buf.WriteString(s.Close)
+	case Comment:
+		buf.WriteString(s.Open + "#")
+		text := []byte(n)
+		for idx, c := range text {
+			if c == s.Escape || c == s.Close[0] ||
+				(idx == len(text)-1 && s.isMarker(text)) {
+				buf.WriteByte(s.Escape)
+			}
+			buf.WriteByte(c)
+		}
+		buf.WriteString(s.Close)
+	case *Directive:
+		buf.WriteString(s.Open)
+		s.space(buf)
+		if n.Index > 0 {
+			buf.WriteString("[" + strconv.Itoa(n.Index) + "]")
+		}
+		if n.Name != "" {
+			buf.WriteString("@" + n.Name)
+			s.space(buf)
+			buf.WriteString(s.Pipe)
+			s.space(buf)
+		}
+		for idx, stage := range n.Stages {
+			if idx > 0 {
+				s.space(buf)
+				buf.WriteString(s.Pipe)
+				s.space(buf)
+			}
+			start := buf.Len()
+			s.writeStage(buf, stage)
+			written := buf.Bytes()[start:]
+
+			// without the optional spaces, the directive could be
+			// mistaken for one with trim markers
+			if s.TrimSpace {
+				continue
+			}
+			if idx == 0 && n.Index == 0 && n.Name == "" && len(written) > 1 &&
+				written[0] == '-' && isSpace(written[1]) {
+				stage := append([]byte{}, written...)
+				buf.Truncate(start)
+				buf.WriteByte(s.Escape)
+				buf.Write(stage)
+				written = buf.Bytes()[start+1:]
+			}
+			if idx == len(n.Stages)-1 && s.isMarker(written) {
+				last := buf.Len() - 1
+				buf.Truncate(last)
+				buf.WriteByte(s.Escape)
+				buf.WriteByte('-')
+			}
+		}
+		s.space(buf)
+		buf.WriteString(s.Close)
+	}
+}
+
+func (s Syntax) writeStage(buf *bytes.Buffer, stage Stage) {
+	s.writeEscaped(buf, []byte(stage.Name), s.nameSpecial())
+	if stage.Params != nil {
+		buf.WriteString(s.Params)
+		s.writeEscaped(buf, stage.Params, s.paramSpecial())
+	}
+}
+
+// isMarker returns whether b ends in a trim marker.
+func (s Syntax) isMarker(b []byte) bool {
+	return len(b) > 1 && b[len(b)-1] == '-' && isSpace(b[len(b)-2])
+}
+
+// space writes out the optional space used to make directives readable.
+func (s Syntax) space(buf *bytes.Buffer) {
+	if s.TrimSpace {
+		buf.WriteByte(' ')
+	}
+}
+
+// writeEscaped writes b to buf, escaping the escape byte and any of the
+// special bytes. If whitespace is trimmed, leading and trailing
+// whitespace is escaped too.
+func (s Syntax) writeEscaped(buf *bytes.Buffer, b []byte, special []byte) {
+	first, last := 0, len(b)
+	if s.TrimSpace {
+		for first < last && isSpace(b[first]) {
+			first++
+		}
+		for last > first && isSpace(b[last-1]) {
+			last--
+		}
+	}
+
+	for idx, c := range b {
+		if c == s.Escape || bytes.IndexByte(special, c) != -1 ||
+			idx < first || idx >= last {
+			buf.WriteByte(s.Escape)
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// nameSpecial and paramSpecial return the bytes that must be escaped in
+// the names and params of stages, respectively, besides the escape byte
+// itself.
+func (s Syntax) nameSpecial() []byte {
+	return []byte{s.Close[0], s.Pipe[0], s.Params[0], '[', '@', '#'}
+}
+
+func (s Syntax) paramSpecial() []byte {
+	return []byte{s.Close[0], s.Pipe[0]}
+}
+
+// unescapes returns whether the escape byte escapes b within the name or
+// params of a stage, rather than being passed through along with it.
+func (s Syntax) unescapes(b byte, inParams bool) bool {
+	special := s.nameSpecial()
+	if inParams {
+		special = append(s.paramSpecial(), s.Params[0])
+	}
+	return b == s.Escape || bytes.IndexByte(special, b) != -1 ||
+		(s.TrimSpace && isSpace(b))
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
@@ -0,0 +1,171 @@
+package strinterp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxParse(t *testing.T) {
+	tests := []struct {
+		syntax Syntax
+		format string
+		nodes  []Node
+	}{
+		{BraceSyntax, "a{{ json | base64:url }}b", []Node{
+			Literal("a"),
+			&Directive{Stages: []Stage{{"json", nil}, {"base64", []byte("url")}}},
+			Literal("b"),
+		}},
+		{BraceSyntax, "{{json|base64:url}}", []Node{
+			&Directive{Stages: []Stage{{"json", nil}, {"base64", []byte("url")}}},
+		}},
+		{BraceSyntax, "100%; {a} {{ {{ }}", []Node{
+			Literal("100%; {a} "), PercentEscape{},
+		}},
+		{BraceSyntax, `\{{ {{ p: a\ b\  }}`, []Node{
+			Literal("{{ "), &Directive{Stages: []Stage{{"p", []byte("a b ")}}},
+		}},
+		{BraceSyntax, "{{ p:a}b\\}}} }}", []Node{
+			&Directive{Stages: []Stage{{"p", []byte("a}b}")}}},
+			Literal(" }}"),
+		}},
+		{BraceSyntax, "{{ [2] RAW }}{{ @user.Name | cdata }}", []Node{
+			&Directive{Index: 2, Stages: []Stage{{"RAW", nil}}},
+			&Directive{Name: "user.Name", Stages: []Stage{{"cdata", nil}}},
+		}},
+		{BraceSyntax, "a{", []Node{Literal("a{")}},
+		{DollarSyntax, "$HOME ${json|base64:url};", []Node{
+			Literal("$HOME "),
+			&Directive{Stages: []Stage{{"json", nil}, {"base64", []byte("url")}}},
+			Literal(";"),
+		}},
+		{DollarSyntax, `${p:a\}b} \${`, []Node{
+			&Directive{Stages: []Stage{{"p", []byte("a}b")}}},
+			Literal(" ${"),
+		}},
+	}
+
+	for _, test := range tests {
+		nodes, err := test.syntax.Parse(test.format)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("for %s, got unexpected error %v", test.format, err))
+		}
+		if !reflect.DeepEqual(nodes, test.nodes) {
+			t.Fatal(fmt.Sprintf("for %s, expected %#v, got %#v", test.format, test.nodes, nodes))
+		}
+
+		again, err := test.syntax.Parse(test.syntax.Format(nodes))
+		if err != nil || !reflect.DeepEqual(again, nodes) {
+			t.Fatal(fmt.Sprintf("for %s, round trip through %s yielded %#v", test.format,
+				test.syntax.Format(nodes), again))
+		}
+	}
+
+	_, err := BraceSyntax.Parse("abc{{ RAW }")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 3 || pe.Err != errIncomplete("}}") ||
+		!strings.Contains(err.Error(), `"}}"`) {
+		t.Fatal("Syntax.Parse does not report incomplete format strings:", err)
+	}
+	_, err = BraceSyntax.Parse("{{ [x] RAW }}")
+	if !errors.As(err, &pe) || pe.Offset != 4 || pe.Err != errBadArgIndex {
+		t.Fatal("Syntax.Parse does not report bad arg indexes:", err)
+	}
+	_, err = BraceSyntax.Parse("{{ @a.. | RAW }}")
+	if !errors.As(err, &pe) || pe.Offset != 3 || pe.Err != errBadArgName {
+		t.Fatal("Syntax.Parse does not report bad arg names:", err)
+	}
+}
+
+func TestSyntaxFormat(t *testing.T) {
+	nodes := []Node{
+		Literal("{a}"),
+		&Directive{Index: 2, Stages: []Stage{{" p", []byte("x} ")}, {"RAW", nil}}},
+		PercentEscape{},
+	}
+	expected := `\{a}{{ [2]\ p:x\}\  | RAW }}{{ {{ }}`
+	format := BraceSyntax.Format(nodes)
+	if format != expected {
+		t.Fatal("expected", expected, "got", format)
+	}
+	if Format(nodes) != `{a}%[2] p:x} |RAW;%%;` {
+		t.Fatal("Format does not use the default syntax:", Format(nodes))
+	}
+}
+
+func TestSetSyntax(t *testing.T) {
+	i := NewDefaultInterpolator()
+	err := i.SetSyntax(BraceSyntax)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := i.InterpStr("width: 100%; {{ RAW }}; {{ {{ }}", "x")
+	if err != nil || res != "width: 100%; x; {{" {
+		t.Fatal("brace syntax interpolation failed:", res, err)
+	}
+
+	tmpl, err := i.Compile("{{ [2] RAW }} {{ RAW | base64 }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = tmpl.String("a", "b", "c")
+	if err != nil || res != "b Yw==" {
+		t.Fatal("brace syntax templates failed:", res, err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = i.InterpReader(buf, strings.NewReader("<{{ cdata }}>{{ {{ }}"), "<")
+	if err != nil || buf.String() != "<&lt;>{{" {
+		t.Fatal("brace syntax streaming failed:", buf.String(), err)
+	}
+
+	_, err = i.InterpStr("{{ RAW ")
+	if err == nil || !strings.Contains(err.Error(), `"}}"`) {
+		t.Fatal("errors do not mention the syntax:", err)
+	}
+	_, err = i.Compile("x{{ RAW |  nope }}")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 11 || pe.Directive != "{{ RAW |  nope }}" {
+		t.Fatal("errors do not point at the stage:", err)
+	}
+
+	for _, syntax := range []Syntax{
+		{Open: "{", Close: "}", Pipe: "|", Escape: '\\'},
+		{Open: "{", Close: "|", Pipe: "|", Params: ":", Escape: '\\'},
+		{Open: "{", Close: "}", Pipe: "||", Params: "|", Escape: '\\'},
+		{Open: "{", Close: "}", Pipe: "[", Params: ":", Escape: '\\'},
+		{Open: "{", Close: "}", Pipe: "@", Params: ":", Escape: '\\'},
+		{Open: "{", Close: " }", Pipe: "|", Params: ":", Escape: '\\', TrimSpace: true},
+		{Open: "{", Close: "}", Pipe: "|", Params: ":", Escape: '{'},
+		{Open: "{", Close: "}", Pipe: "|", Params: ":", Escape: '|'},
+		{Open: "{", Close: "}", Pipe: "|", Params: ":", Escape: ' ', TrimSpace: true},
+	} {
+		err = i.SetSyntax(syntax)
+		if _, isBadSyntax := err.(errBadSyntax); !isBadSyntax {
+			t.Fatal(fmt.Sprintf("for %#v, expected a bad syntax error, got %v", syntax, err))
+		}
+		_, err = syntax.Parse("")
+		if _, isBadSyntax := err.(errBadSyntax); !isBadSyntax {
+			t.Fatal(fmt.Sprintf("for %#v, Parse did not validate: %v", syntax, err))
+		}
+	}
+	// and the Interpolator kept its syntax
+	res, err = i.InterpStr("{{ RAW }}", "x")
+	if err != nil || res != "x" {
+		t.Fatal("SetSyntax changed the syntax on error:", res, err)
+	}
+
+	err = i.SetSyntax(DollarSyntax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = i.InterpStr("echo $HOME ${RAW|base64};", "a")
+	if err != nil || res != "echo $HOME YQ==;" {
+		t.Fatal("dollar syntax interpolation failed:", res, err)
+	}
+}
@@ -0,0 +1,542 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// This file contains the compiled form of a format string, and the code
+// that executes it.
+
+// A Template is a format string that has been parsed and resolved against
+// an Interpolator once, so it can be executed any number of times without
+// re-parsing the format string or re-resolving its formatters and
+// encoders.
+//
+// Templates are created via Interpolator.Compile or
+// Interpolator.MustCompile. Like the Interpolator that created it, a
+// Template can be freely used in any number of goroutines, but note that
+// it holds on to the formatters and encoders it resolved at compile time.
+type Template struct {
+	i        *Interpolator
+	segments []segment
+	// the directive with the highest explicit argument index, if any
+	widest *directive
+	// which positional args the template uses
+	used    []bool
+	strict  bool
+	dialect SQLDialect
+}
+
+// A segment is either a run of literal bytes to be written out verbatim,
+// or a directive. Exactly one of the two is set.
+type segment struct {
+	literal   []byte
+	directive *directive
+}
+
+// A directive is a single %...; specification with all names resolved.
+//
+// Exactly one of formatter or encoder is set, corresponding to the first
+// element of the pipeline after any each. pipeline is the rest of the
+// pipeline, in the order it was written in the format string.
+type directive struct {
+	formatter Formatter
+	encoder   Encoder
+	params    []byte
+	pipeline  []stage
+	// if set, the directive runs its pipeline on each element of its arg
+	// in turn, writing sep between them
+	each bool
+	sep  []byte
+	// if set, the directive opens or closes a block instead
+	block blockKind
+	// for an end, the kind of block it closes
+	closes blockKind
+	// for an if, whether it only tests that its arg was given
+	ifGiven bool
+	// if set, the directive executes this template, writing it through
+	// the pipeline
+	template *Template
+	// if set, the directive writes a placeholder for its arg in SQL
+	// mode, or one for each of its elements if sqlList is set
+	sqlArg  bool
+	sqlList bool
+
+	// the 0-based index of the arg this directive uses, and whether that
+	// was explicitly given in the format string
+	arg         int
+	explicitArg bool
+	// if set, the directive uses this named arg instead
+	path []string
+
+	// where the directive came from, for error reporting
+	*directivePos
+}
+
+type stage struct {
+	encoder Encoder
+	params  []byte
+}
+
+// Compile parses the given format string and resolves all of its
+// formatters and encoders, returning a *Template that can be executed
+// repeatedly.
+//
+// Malformed format strings and unknown formatters or encoders are
+// reported here as a *ParseError, rather than when the template is
+// executed.
+func (i *Interpolator) Compile(format string) (*Template, error) {
+	return i.compile([]byte(format))
+}
+
+// MustCompile is like Compile, but panics if the format string can not be
+// compiled. It is intended for initializing package-level variables with
+// constant format strings.
+func (i *Interpolator) MustCompile(format string) *Template {
+	t, err := i.Compile(format)
+	if err != nil {
+		panic("strinterp: Compile(" + format + "): " + err.Error())
+	}
+	return t
+}
+
+func (i *Interpolator) compile(formatBytes []byte) (*Template, error) {
+	return i.compileWith(formatBytes, &i.syntax)
+}
+
+// compileWith compiles a format string written in the given Syntax,
+// which is not necessarily the Interpolator's current one.
+func (i *Interpolator) compileWith(formatBytes []byte, syn *Syntax) (*Template, error) {
+	t := &Template{i: i, strict: i.strict, dialect: i.dialect}
+	p := newParser(formatBytes, syn)
+	c := &compiler{i: i}
+
+	for {
+		node, pos, err := p.next()
+		if err == io.EOF {
+			t.used = c.used
+			return t, c.finish()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch n := node.(type) {
+		case Literal:
+			t.addLiteral([]byte(n))
+		case PercentEscape:
+			t.addLiteral([]byte(syn.Open))
+		case *Directive:
+			d, err := c.directive(n, pos)
+			if err != nil {
+				return nil, err
+			}
+			if d.explicitArg && (t.widest == nil || d.arg > t.widest.arg) {
+				t.widest = d
+			}
+			t.segments = append(t.segments, segment{directive: d})
+		}
+	}
+}
+
+// addLiteral adds literal text to the template, merging it with any
+// literal text immediately before it.
+func (t *Template) addLiteral(literal []byte) {
+	last := len(t.segments) - 1
+	if last >= 0 && t.segments[last].directive == nil {
+		t.segments[last].literal = append(t.segments[last].literal, literal...)
+		return
+	}
+	t.segments = append(t.segments, segment{literal: literal})
+}
+
+// A compiler holds the state needed to compile the directives of a
+// format string, which must be fed to it in order.
+type compiler struct {
+	i *Interpolator
+	// the index of the arg the next directive without an explicit index
+	// will use
+	nextArg int
+	// which positional args have been used so far
+	used []bool
+	// the directives that opened the blocks that are still open, innermost
+	// last
+	blocks []*directive
+}
+
+// directive resolves the given directive and assigns it its arg,
+// reporting any problems as a *ParseError.
+func (c *compiler) directive(n *Directive, pos *directivePos) (*directive, error) {
+	d, stageIdx, err := c.i.compileDirective(n)
+	if err != nil {
+		at := pos.stages[stageIdx]
+		return nil, &ParseError{pos.offset + at, pos.index, pos.raw,
+			caretSnippet(pos.raw, at), err}
+	}
+	d.directivePos = pos
+
+	if d.block != noBlock {
+		err = c.block(d)
+		if err != nil || d.block != ifBlock {
+			return d, err
+		}
+	}
+
+	if n.Name != "" {
+		d.path = strings.Split(n.Name, ".")
+		return d, nil
+	}
+
+	// as with fmt, an explicit index also moves where the following
+	// implicit directives pick up from
+	if n.Index > 0 {
+		c.nextArg = n.Index - 1
+		d.explicitArg = true
+	}
+	d.arg = c.nextArg
+
+	// an include uses as many args as its template does
+	used := []bool{true}
+	if d.template != nil {
+		used = d.template.used
+	}
+	c.nextArg += len(used)
+
+	for len(c.used) < d.arg+len(used) {
+		c.used = append(c.used, false)
+	}
+	for j, isUsed := range used {
+		c.used[d.arg+j] = c.used[d.arg+j] || isUsed
+	}
+
+	return d, nil
+}
+
+// compileDirective resolves the given directive. If this fails, it also
+// returns the index of the pipeline element that failed.
+func (i *Interpolator) compileDirective(n *Directive) (*directive, int, error) {
+	stages := n.Stages
+	if blockKinds[stages[0].Name] != noBlock {
+		return i.compileBlock(n)
+	}
+	if stages[0].Name == "include" {
+		return i.compileInclude(n)
+	}
+	if i.dialect != noSQL && sqlKeywords[stages[0].Name] {
+		return i.compileSQLArg(n)
+	}
+
+	d := &directive{}
+
+	// each applies the rest of the pipeline to the elements of the arg
+	skipped := 0
+	if stages[0].Name == "each" {
+		params, err := eachSpec.Parse(stages[0].Params)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(stages) == 1 {
+			return nil, 0, errEachNoPipeline
+		}
+		d.each = true
+		d.sep = []byte(params.String("sep"))
+		stages = stages[1:]
+		skipped = 1
+	}
+
+	pipeline, at, err := i.compilePipeline(stages[1:], skipped+1)
+	if err != nil {
+		return nil, at, err
+	}
+	d.pipeline = pipeline
+
+	// If the first element specifies a "formatter", then we will just
+	// pass off the argument to the formatter. If the first element
+	// specifies an "encoder", then we will convert the argument to
+	// something that we can "Write" with ourselves. If it's neither,
+	// well, that's a problem.
+	first := stages[0]
+	d.formatter, err = i.resolveFormatter(first)
+	if err == nil && d.formatter == nil {
+		d.encoder, err = i.resolveEncoder(first)
+	}
+	if err != nil {
+		return nil, skipped, err
+	}
+	if d.formatter == nil && d.encoder == nil {
+		return nil, skipped, errUnknownFormatter(first.Name)
+	}
+	d.params = first.Params
+
+	return d, 0, nil
+}
+
+// compilePipeline resolves the encoders of a pipeline, whose first stage
+// is at the given offset in its directive. If this fails, it also returns
+// the index of the stage that failed.
+func (i *Interpolator) compilePipeline(stages []Stage, offset int) ([]stage, int, error) {
+	var pipeline []stage
+	for j, s := range stages {
+		encoder, err := i.resolveEncoder(s)
+		if err != nil {
+			return nil, offset + j, err
+		}
+		if encoder == nil {
+			return nil, offset + j, errUnknownEncoder(s.Name)
+		}
+		pipeline = append(pipeline, stage{encoder, s.Params})
+	}
+	return pipeline, 0, nil
+}
+
+// resolveFormatter returns the formatter named by the stage, or nil if
+// there isn't one. Formatters with a ParamSpec have their parameters
+// parsed here, once, and are returned wrapped up with them; any
+// ParamValidator then checks what the ParamSpec can't.
+func (i *Interpolator) resolveFormatter(s Stage) (Formatter, error) {
+	pf, isParamFormatter := i.paramFormatters[s.Name]
+	if !isParamFormatter {
+		return i.formatters[s.Name], i.validateParams(s)
+	}
+	params, err := pf.spec.Parse(s.Params)
+	if err == nil {
+		err = i.validateParams(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, val interface{}, _ []byte) error {
+		return pf.handler(w, val, params)
+	}, nil
+}
+
+// validateParams runs the ParamValidator of the stage's formatter or
+// encoder, if it has one.
+func (i *Interpolator) validateParams(s Stage) error {
+	validator := i.validators[s.Name]
+	if validator == nil {
+		return nil
+	}
+	return validator(s.Params)
+}
+
+// resolveEncoder is the encoder equivalent of resolveFormatter.
+func (i *Interpolator) resolveEncoder(s Stage) (Encoder, error) {
+	pe, isParamEncoder := i.paramEncoders[s.Name]
+	if !isParamEncoder {
+		return i.encoders[s.Name], i.validateParams(s)
+	}
+	params, err := pe.spec.Parse(s.Params)
+	if err == nil {
+		err = i.validateParams(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, _ []byte) (io.Writer, error) {
+		return pe.handler(w, params)
+	}, nil
+}
+
+// Execute interpolates the template into the passed io.Writer, consuming
+// args from left to right as directives are encountered, except where
+// directives give explicit argument indexes.
+//
+// If any directive's explicit argument index is out of range for the
+// given args, that is reported before anything is written.
+//
+// If a directive fails, the error is returned as a *DirectiveError.
+func (t *Template) Execute(w io.Writer, args ...interface{}) error {
+	return t.execute(&execution{i: t.i, w: w, args: args, strict: t.strict})
+}
+
+// Strict returns a copy of the Template in strict mode. See
+// Interpolator.SetStrict.
+func (t *Template) Strict() *Template {
+	strict := *t
+	strict.strict = true
+	return &strict
+}
+
+// ExecuteNamed interpolates the template into the passed io.Writer,
+// resolving named arguments like "%@user.Name|cdata;" against data.
+//
+// data should be a map with string keys or a struct, or a pointer to one.
+// The components of the dotted path descend through nested maps, structs
+// and pointers. Struct fields are matched by the name given in their
+// "strinterp" struct tag if they have one, and by their field name
+// otherwise; a tag of "-" hides the field. Only exported fields can be
+// used. If a name can not be resolved, the directive receives NotGiven.
+//
+// Directives that use positional arguments also receive NotGiven, except
+// those with explicit argument indexes, which are an error.
+func (t *Template) ExecuteNamed(w io.Writer, data interface{}) error {
+	return t.execute(&execution{i: t.i, w: w, named: data, strict: t.strict})
+}
+
+func (t *Template) execute(e *execution) error {
+	if e.strict {
+		err := checkArity(t.used, len(e.args), e.args)
+		if err != nil {
+			return err
+		}
+	}
+	if t.widest != nil && t.widest.arg >= len(e.args) {
+		d := t.widest
+		return &DirectiveError{d.offset, d.index, d.raw, errArgIndexOutOfRange}
+	}
+
+	for _, seg := range t.segments {
+		var err error
+		if seg.directive == nil {
+			err = e.literal(seg.literal)
+		} else {
+			err = e.directive(seg.directive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String is a convenience function that executes the template and
+// returns the resulting string.
+func (t *Template) String(args ...interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	err := t.Execute(buf, args...)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// An execution holds the state of a single interpolation, as literals and
+// directives are fed to it in order.
+type execution struct {
+	i      *Interpolator
+	w      io.Writer
+	args   []interface{}
+	named  interface{}
+	strict bool
+	// the begin blocks currently being executed, innermost last
+	regions []region
+	// if non-zero, a section ruled out by an if is being skipped, and
+	// this is one more than the number of blocks opened within it
+	skip int
+	// in SQL mode, the query arguments collected so far
+	sql *sqlQuery
+}
+
+func (e *execution) literal(literal []byte) error {
+	if e.skip > 0 {
+		return nil
+	}
+	_, err := e.w.Write(literal)
+	return err
+}
+
+// directive executes the given directive on its arg, wrapping any
+// failure in a *DirectiveError.
+func (e *execution) directive(d *directive) error {
+	if e.skip > 0 {
+		e.skipBlock(d)
+		return nil
+	}
+	if d.block != noBlock && d.block != ifBlock {
+		err := e.block(d)
+		if err != nil {
+			return &DirectiveError{d.offset, d.index, d.raw, err}
+		}
+		return nil
+	}
+
+	if d.template != nil {
+		return e.include(d)
+	}
+
+	var thisArg interface{} = NotGiven
+	if d.path != nil {
+		thisArg = lookupName(e.named, d.path)
+	} else if d.arg < len(e.args) {
+		thisArg = e.args[d.arg]
+	} else if d.explicitArg {
+		return &DirectiveError{d.offset, d.index, d.raw, errArgIndexOutOfRange}
+	} else if e.strict {
+		return &DirectiveError{d.offset, d.index, d.raw,
+			ErrMissingArguments{d.arg + 1, len(e.args)}}
+	}
+
+	if d.block == ifBlock {
+		if !d.test(thisArg) {
+			e.skip = 1
+		}
+		return nil
+	}
+
+	var err error
+	if d.sqlArg {
+		err = e.placeholder(d, thisArg)
+	} else {
+		err = e.i.execDirective(e.w, d, thisArg)
+	}
+	if err != nil {
+		return &DirectiveError{d.offset, d.index, d.raw, err}
+	}
+	return nil
+}
+
+func (i *Interpolator) execDirective(w io.Writer, d *directive, arg interface{}) error {
+	if d.each {
+		return i.execEach(w, d, arg)
+	}
+	return i.execPipeline(w, d, arg)
+}
+
+// newPipelineWriter returns a WriterStack writing to w through the
+// encoders of the pipeline.
+func newPipelineWriter(w io.Writer, pipeline []stage) (*WriterStack, error) {
+	writer := NewWriterStack(w)
+
+	// if there are encoders in the specification, we construct them
+	// backwards so as to properly modify the underlying writer.
+	for j := len(pipeline) - 1; j >= 0; j-- {
+		err := writer.Push(pipeline[j].encoder, pipeline[j].params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// execPipeline runs the arg through the directive's formatter or encoder
+// and pipeline, with a WriterStack of its own.
+func (i *Interpolator) execPipeline(w io.Writer, d *directive, arg interface{}) error {
+	writer, err := newPipelineWriter(w, d.pipeline)
+	if err != nil {
+		return err
+	}
+
+	if d.formatter != nil {
+		err := d.formatter(writer, arg, d.params)
+		err2 := writer.Finish()
+		if err != nil {
+			return err
+		}
+		return err2
+	}
+
+	err = writer.Push(d.encoder, d.params)
+	if err != nil {
+		return err
+	}
+	err = i.writeArgument(arg, writer)
+	err2 := writer.Finish()
+	if err != nil {
+		return err
+	}
+	return err2
+}
@@ -0,0 +1,442 @@
+package strinterp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	tmpl, err := i.Compile("<b>%cdata;</b>%%;%RAW|base64;")
+	if err != nil {
+		t.Fatal("could not compile template:", err)
+	}
+
+	// the whole point is reuse, so execute it a few times
+	for _, val := range []string{"a<b", "", "&"} {
+		res, err := tmpl.String(val, val)
+		if err != nil {
+			t.Fatal("could not execute template:", err)
+		}
+		expected, _ := i.InterpStr("<b>%cdata;</b>%%;%RAW|base64;", val, val)
+		if res != expected {
+			t.Fatal(fmt.Sprintf("for %q, expected '%s', got '%s'", val, expected, res))
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, "x", "y")
+	if err != nil || buf.String() != "<b>x</b>%eQ==" {
+		t.Fatal("Execute does not work correctly:", buf.String(), err)
+	}
+
+	_, err = tmpl.String()
+	if !errors.Is(err, ErrNotGiven) {
+		t.Fatal("Execute does not report missing arguments:", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	tests := []struct {
+		format string
+		err    error
+	}{
+		{"x%RAW", errIncompleteFormatString},
+		{"x%blargh;", errUnknownFormatter("blargh")},
+		{"x%RAW|blargh;", errUnknownEncoder("blargh")},
+		{"%json;%RAW|json;", errUnknownEncoder("json")},
+	}
+
+	for _, test := range tests {
+		tmpl, err := i.Compile(test.format)
+		if tmpl != nil || !reflect.DeepEqual(rootCause(err), test.err) {
+			t.Fatal(fmt.Sprintf("for %s, expected error '%v', got '%v'", test.format, test.err, err))
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("MustCompile does not panic on bad format strings")
+			}
+		}()
+		i.MustCompile("%blargh;")
+	}()
+
+	if i.MustCompile("%RAW;") == nil {
+		t.Fatal("MustCompile does not return the template")
+	}
+}
+
+// Unknown formatters must be reported without writing anything, even if
+// there is literal text before them.
+func TestInterpWriterCompilesFirst(t *testing.T) {
+	i := NewInterpolator()
+
+	buf := new(bytes.Buffer)
+	err := i.InterpWriter(buf, []byte("literal %RAW; %blargh;"), "x")
+	if err == nil || buf.Len() != 0 {
+		t.Fatal("InterpWriter wrote output for an invalid format string")
+	}
+}
+
+// InterpStr compiles its format string on every call, so the parser has
+// to stay cheap to set up. Reading the format through a bufio.Reader cost
+// over 4KB a call before anything was parsed.
+func TestInterpStrAllocs(t *testing.T) {
+	i := NewDefaultInterpolator()
+	interp := func() {
+		_, _ = i.InterpStr("<a title=\"%cdata;\">%cdata;</a>", "title", "text")
+	}
+	interp()
+
+	const runs = 100
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for n := 0; n < runs; n++ {
+		interp()
+	}
+	runtime.ReadMemStats(&after)
+
+	allocs := (after.Mallocs - before.Mallocs) / runs
+	bytesPer := (after.TotalAlloc - before.TotalAlloc) / runs
+	if allocs > 64 || bytesPer > 4096 {
+		t.Fatal(fmt.Sprintf("InterpStr allocates %d times, %d bytes, per call", allocs, bytesPer))
+	}
+}
+
+func BenchmarkInterpStr(b *testing.B) {
+	i := NewDefaultInterpolator()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = i.InterpStr("<a title=\"%cdata;\">%cdata;</a>", "title", "text")
+	}
+}
+
+func BenchmarkTemplateString(b *testing.B) {
+	i := NewDefaultInterpolator()
+	tmpl := i.MustCompile("<a title=\"%cdata;\">%cdata;</a>")
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = tmpl.String("title", "text")
+	}
+}
+
+// closeRecorder is an io.ReadCloser argument that records being closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestNewReader(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	arg := &closeRecorder{Reader: strings.NewReader("<streamed>")}
+	r := i.NewReader("a%cdata;b%RAW|base64;", arg, "x")
+	res, err := ioutil.ReadAll(r)
+	if err != nil || string(res) != "a&lt;streamed&gt;beA==" {
+		t.Fatal("NewReader does not produce the right output:", string(res), err)
+	}
+	if arg.closed {
+		t.Fatal("NewReader closed its arguments before being closed")
+	}
+	if r.Close() != nil || !arg.closed {
+		t.Fatal("NewReader does not close its arguments")
+	}
+	if r.Close() != nil {
+		t.Fatal("NewReader can not be closed twice")
+	}
+	if _, err = r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("NewReader can be read after being closed")
+	}
+
+	// errors come out of Read, after the output before them
+	r = i.NewReader("a%cdata;", 1)
+	res, err = ioutil.ReadAll(r)
+	if !errors.Is(err, errNoDefaultHandling) || string(res) != "a" {
+		t.Fatal("NewReader does not propagate errors:", string(res), err)
+	}
+	r.Close()
+
+	r = i.NewReader("a%blargh;")
+	var pe *ParseError
+	if _, err = r.Read(make([]byte, 10)); !errors.As(err, &pe) {
+		t.Fatal("NewReader does not propagate compile errors:", err)
+	}
+	r.Close()
+
+	// closing in the middle stops the interpolation
+	arg = &closeRecorder{Reader: strings.NewReader(strings.Repeat("x", 100000))}
+	r = i.MustCompile("%RAW;").NewReader(arg)
+	if n, err := r.Read(make([]byte, 10)); n == 0 || err != nil {
+		t.Fatal("NewReader can not be read from:", err)
+	}
+	if r.Close() != nil || !arg.closed {
+		t.Fatal("NewReader does not close its arguments when interrupted")
+	}
+
+	// closing before reading never starts it
+	arg = &closeRecorder{Reader: strings.NewReader("x")}
+	r = i.NewReader("%RAW;", arg)
+	if r.Close() != nil || !arg.closed {
+		t.Fatal("NewReader does not close its arguments when never read")
+	}
+	if _, err = r.Read(make([]byte, 10)); err != io.ErrClosedPipe {
+		t.Fatal("NewReader can be read after being closed:", err)
+	}
+}
+
+func TestExplicitArgIndexes(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	tests := []struct {
+		format string
+		args   []interface{}
+		result string
+	}{
+		{"%[2]RAW;%[1]RAW;", []interface{}{"a", "b"}, "ba"},
+		{"%[1]RAW;%[1]RAW|base64;", []interface{}{"a"}, "aYQ=="},
+		// implicit directives pick up after the last explicit one
+		{"%[2]RAW;%RAW;%[1]RAW;%RAW;", []interface{}{"a", "b", "c"}, "bcab"},
+		{"%RAW;%[3]RAW;%RAW;", []interface{}{"a", "b", "c", "d"}, "acd"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.args...)
+		if err != nil || res != test.result {
+			t.Fatal(fmt.Sprintf("for %s, expected '%s', got '%s' (%v)", test.format, test.result, res, err))
+		}
+	}
+
+	// implicit directives past the end still get NotGiven
+	_, err := i.InterpStr("%[1]RAW;%RAW;", "a")
+	if !errors.Is(err, ErrNotGiven) {
+		t.Fatal("implicit directives after explicit ones do not get NotGiven:", err)
+	}
+
+	// but explicit directives out of range fail before any output
+	tmpl := i.MustCompile("a%RAW;%[3]RAW;%[2]RAW;")
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, "x", "y")
+	var de *DirectiveError
+	if !errors.As(err, &de) || de.Err != errArgIndexOutOfRange || de.Index != 1 || buf.Len() != 0 {
+		t.Fatal("out of range arg indexes are not caught before output:", err, buf.String())
+	}
+
+	// which isn't possible when streaming, but still fails
+	err = i.InterpReader(buf, strings.NewReader("a%RAW;%[3]RAW;"), "x", "y")
+	if !errors.As(err, &de) || de.Err != errArgIndexOutOfRange || buf.String() != "ax" {
+		t.Fatal("out of range arg indexes are not caught when streaming:", err)
+	}
+}
+
+type namedInner struct {
+	Title string
+}
+
+type NamedEmbedded struct {
+	Promoted string
+}
+
+type namedOuter struct {
+	*NamedEmbedded
+	Name    string
+	Tagged  string `strinterp:"tag"`
+	Hidden  string `strinterp:"-"`
+	private string
+	Inner   namedInner
+	Ptr     *namedInner
+	Map     map[string]interface{}
+}
+
+func TestNamedArguments(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	data := namedOuter{
+		NamedEmbedded: &NamedEmbedded{"promoted"},
+		Name:          "<name>",
+		Tagged:        "tagged",
+		Hidden:        "hidden",
+		private:       "private",
+		Inner:         namedInner{"inner"},
+		Ptr:           &namedInner{"ptr"},
+		Map: map[string]interface{}{
+			"key":    "value",
+			"nested": map[string]string{"deep": "deeper"},
+			"nil":    nil,
+		},
+	}
+
+	tests := []struct {
+		format string
+		result string
+	}{
+		{"%@Name|cdata;", "&lt;name&gt;"},
+		{"%@tag|RAW;", "tagged"},
+		{"%@Promoted|RAW;", "promoted"},
+		{"%@Inner.Title|RAW;", "inner"},
+		{"%@Ptr.Title|RAW|base64;", "cHRy"},
+		{"%@Map.key|RAW;", "value"},
+		{"%@Map.nested.deep|RAW;", "deeper"},
+		{"%@Map.nil|json;", "null\n"},
+		{"%@Name|RAW;, %@Name|cdata;", "<name>, &lt;name&gt;"},
+	}
+
+	for _, test := range tests {
+		for _, arg := range []interface{}{data, &data} {
+			res, err := i.InterpNamed(test.format, arg)
+			if err != nil || res != test.result {
+				t.Fatal(fmt.Sprintf("for %s, expected '%s', got '%s' (%v)", test.format, test.result, res, err))
+			}
+		}
+	}
+
+	// missing names are NotGiven
+	var given interface{}
+	i.AddFormatter("given", func(w io.Writer, arg interface{}, params []byte) error {
+		given = arg
+		return nil
+	})
+	missing := []string{
+		"Missing", "Tagged", "Hidden", "private", "Name.Deeper",
+		"Map.missing", "Map.nil.deeper", "Map.nested.missing", "Map.key.deeper",
+	}
+	for _, name := range missing {
+		_, err := i.InterpNamed("%@"+name+"|given;", data)
+		if err != nil || given != NotGiven {
+			t.Fatal(fmt.Sprintf("for %s, expected NotGiven, got %#v (%v)", name, given, err))
+		}
+	}
+	for _, arg := range []interface{}{nil, 1, (*namedOuter)(nil), map[int]string{1: "a"},
+		namedOuter{}} {
+		_, err := i.InterpNamed("%@1|given;", arg)
+		if err != nil || given != NotGiven {
+			t.Fatal(fmt.Sprintf("for %#v, expected NotGiven, got %#v (%v)", arg, given, err))
+		}
+	}
+	_, err := i.InterpNamed("%@Promoted|given;", namedOuter{})
+	if err != nil || given != NotGiven {
+		t.Fatal("fields promoted through nil pointers are not NotGiven")
+	}
+
+	// positional args are NotGiven, or an error if explicit
+	_, err = i.InterpNamed("%@Name|RAW;%RAW;", data)
+	if !errors.Is(err, ErrNotGiven) {
+		t.Fatal("positional directives are not NotGiven:", err)
+	}
+	_, err = i.InterpNamed("%@Name|RAW;%[1]RAW;", data)
+	if !errors.Is(err, errArgIndexOutOfRange) {
+		t.Fatal("explicit positional directives do not fail:", err)
+	}
+
+	// and named directives don't consume positional args
+	res, err := i.InterpStr("%RAW;%@Name|given;%RAW;", "a", "b")
+	if err != nil || res != "ab" {
+		t.Fatal("named directives consume positional args:", res, err)
+	}
+
+	_, err = i.InterpNamed("%blargh;", data)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatal("InterpNamed does not report compile errors")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	i := NewDefaultInterpolator()
+
+	tests := []struct {
+		format string
+		args   []interface{}
+		err    error
+	}{
+		{"x", []interface{}{}, nil},
+		{"%RAW;%%;%cdata;", []interface{}{"a", "b"}, nil},
+		{"%RAW;%cdata;", []interface{}{"a"}, ErrMissingArguments{2, 1}},
+		{"%RAW;", []interface{}{"a", 1, nil},
+			ErrExtraArguments{[]int{2, 3}, []string{"int", "nil"}}},
+		{"%[2]RAW;", []interface{}{1, "a"}, ErrExtraArguments{[]int{1}, []string{"int"}}},
+		{"%[2]RAW;%[1]RAW;", []interface{}{"a", "b"}, nil},
+		{"%[2]RAW;", []interface{}{"a"}, ErrMissingArguments{2, 1}},
+		{"%@a|RAW;", []interface{}{"a"}, ErrExtraArguments{[]int{1}, []string{"string"}}},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		err := i.Strict().InterpWriter(buf, []byte(test.format), test.args...)
+		if !reflect.DeepEqual(err, test.err) {
+			t.Fatal(fmt.Sprintf("for %s, expected '%v', got '%v'", test.format, test.err, err))
+		}
+		if err != nil && buf.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, output was written before the error", test.format))
+		}
+
+		err = i.CheckArity(test.format, len(test.args))
+		if extra, isExtra := test.err.(ErrExtraArguments); isExtra {
+			extra.Types = nil
+			test.err = extra
+		}
+		if !reflect.DeepEqual(err, test.err) {
+			t.Fatal(fmt.Sprintf("for %s, CheckArity expected '%v', got '%v'", test.format, test.err, err))
+		}
+	}
+
+	// not strict unless asked
+	_, err := i.InterpStr("%RAW;", "a", "b")
+	if err != nil {
+		t.Fatal("Interpolators are strict by default")
+	}
+	tmpl := i.MustCompile("%RAW;")
+	if tmpl.Execute(ioutil.Discard, "a", "b") != nil {
+		t.Fatal("Templates are strict by default")
+	}
+	if tmpl.Strict().Execute(ioutil.Discard, "a", "b") == nil {
+		t.Fatal("Template.Strict does not work")
+	}
+	i.SetStrict(true)
+	_, err = i.InterpStr("%RAW;", "a", "b")
+	if !reflect.DeepEqual(err, ErrExtraArguments{[]int{2}, []string{"string"}}) {
+		t.Fatal("SetStrict does not work:", err)
+	}
+	err = i.MustCompile("%RAW;").ExecuteNamed(ioutil.Discard, nil)
+	if !reflect.DeepEqual(err, ErrMissingArguments{1, 0}) {
+		t.Fatal("ExecuteNamed is not strict:", err)
+	}
+
+	// streaming can only check as it goes
+	buf := new(bytes.Buffer)
+	err = i.InterpReader(buf, strings.NewReader("%RAW;%RAW;"), "a", "b", "c")
+	if !reflect.DeepEqual(err, ErrExtraArguments{[]int{3}, []string{"string"}}) || buf.String() != "ab" {
+		t.Fatal("InterpReader does not detect extra args in strict mode:", err)
+	}
+	buf.Reset()
+	err = i.InterpReader(buf, strings.NewReader("%RAW;%[3]RAW;%RAW;"), "a", "b", "c")
+	if !errors.Is(err, ErrMissingArguments{4, 3}) || buf.String() != "ac" {
+		t.Fatal("InterpReader does not detect missing args in strict mode:", err)
+	}
+
+	if i.CheckArity("%blargh;", 0) == nil {
+		t.Fatal("CheckArity does not report compile errors")
+	}
+
+	// and don't crash
+	_ = ErrMissingArguments{1, 0}.Error()
+	_ = ErrExtraArguments{[]int{1, 2}, []string{"int"}}.Error()
+}
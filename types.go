@@ -3,6 +3,8 @@ package strinterp
 import (
 	"errors"
 	"io"
+	"reflect"
+	"strconv"
 )
 
 // This file bundles together all the various type definitions and such
@@ -50,10 +52,162 @@ type Formatter func(io.Writer, interface{}, []byte) error
 // This is public so your formatter can check for it.
 var ErrNotGiven = errors.New("value not given")
 
-var errIncompleteFormatString = errors.New("incomplete format string, no semi-colon found")
+// errIncomplete and errTooLong record the close delimiter that was not
+// found.
+type errIncomplete string
+
+func (ei errIncomplete) Error() string {
+	return "incomplete format string, no closing " + strconv.Quote(string(ei)) + " found"
+}
+
+type errTooLong string
+
+func (etl errTooLong) Error() string {
+	return "directive too long, no closing " + strconv.Quote(string(etl)) + " found"
+}
+
+var errIncompleteFormatString = errIncomplete(";")
+
+var errDirectiveTooLong = errTooLong(";")
+
+type errBadSyntax string
+
+func (ebs errBadSyntax) Error() string {
+	return "bad syntax: " + string(ebs)
+}
+
+type errBadParamSpec string
+
+func (ebps errBadParamSpec) Error() string {
+	return "bad parameter spec: " + string(ebps)
+}
+
+type errBadParamValue string
+
+func (ebpv errBadParamValue) Error() string {
+	return string(ebpv)
+}
+
+var errBadArgIndex = errors.New("bad argument index, must be a positive number in brackets")
+
+var errArgIndexOutOfRange = errors.New("argument index out of range")
+
+var errEscapeArg = errors.New("an escaped open delimiter can not have an argument index or name")
+
+var errBadArgName = errors.New("bad argument name, must be a dotted path followed by a pipe")
+
+var errNoPipeline = errors.New("named argument must be followed by a pipeline")
 
 var errNoDefaultHandling = errors.New("no default encoder handling for type")
 
+var errEachNoPipeline = errors.New("each must be followed by a pipeline")
+
+var errBlockArg = errors.New("only if takes an argument")
+
+var errBeginNoPipeline = errors.New("begin must be followed by a pipeline of encoders")
+
+var errBlockPipeline = errors.New("only begin can have a pipeline")
+
+var errUnmatchedEnd = errors.New("end without a matching begin or if")
+
+var errElseWithoutIf = errors.New("else without a matching if")
+
+var errUnclosedBegin = errors.New("begin without a matching end")
+
+var errUnclosedIf = errors.New("if without a matching end")
+
+var errNotIterable = errors.New("each needs a slice, array, channel or iterator function")
+
+var errIncludeNoTemplate = errors.New("include must name a template")
+
+// errUnknownTemplate is returned when a format string includes a template
+// that has not been added to the interpolator.
+type errUnknownTemplate string
+
+func (ut errUnknownTemplate) Error() string {
+	return "format string included unknown template " + string(ut)
+}
+
+// errTemplateExists is returned when a template is added under a name
+// that another template already has.
+type errTemplateExists string
+
+func (te errTemplateExists) Error() string {
+	return "template " + string(te) + " has already been added"
+}
+
+var errRelativeURL = errors.New("relative URLs are not allowed")
+
+// errDisallowedScheme is returned by the url encoder for a URL whose
+// scheme is not allowed.
+type errDisallowedScheme string
+
+func (ds errDisallowedScheme) Error() string {
+	return "URL scheme " + strconv.Quote(string(ds)) + " is not allowed"
+}
+
+var errEmptyCSSIdent = errors.New("CSS identifiers can not be empty")
+
+// errInvalidCSS is returned by the validating CSS encoders, naming the
+// kind of value that was expected.
+type errInvalidCSS string
+
+func (ic errInvalidCSS) Error() string {
+	return "not a valid CSS " + string(ic)
+}
+
+var errUnknownSQLDialect = errors.New("unknown SQL dialect")
+
+var errNotSQLMode = errors.New("arg and args can only be used in SQL mode, with InterpSQL or ExecuteSQL")
+
+var errSQLArgPipeline = errors.New("arg and args can not have a pipeline")
+
+var errEmptySQLList = errors.New("args needs at least one value")
+
+var errBytesSQLList = errors.New("args needs a list of values, not a []byte; use arg for a single value")
+
+var errNoIdentDialect = errors.New("sqlident needs a dialect outside of SQL mode")
+
+var errEmptySQLIdent = errors.New("SQL identifiers can not be empty")
+
+var errBadSQLIdent = errors.New("SQL identifiers can not contain NUL or invalid UTF-8")
+
+// errUnknownIdentifierSet is returned by the sqlident encoder for an
+// allow parameter naming a set that has not been added to the
+// interpolator.
+type errUnknownIdentifierSet string
+
+func (uis errUnknownIdentifierSet) Error() string {
+	return "unknown identifier set " + strconv.Quote(string(uis))
+}
+
+// errIdentifierSetExists is returned when an identifier set is added
+// under a name that another set already has.
+type errIdentifierSetExists string
+
+func (ise errIdentifierSetExists) Error() string {
+	return "identifier set " + strconv.Quote(string(ise)) + " has already been added"
+}
+
+// errIdentifierNotAllowed is returned by the sqlident encoder for an
+// identifier that is not in its allowed set.
+type errIdentifierNotAllowed string
+
+func (ina errIdentifierNotAllowed) Error() string {
+	return "SQL identifier " + strconv.Quote(string(ina)) + " is not allowed"
+}
+
+var errShellNUL = errors.New("shell words can not contain NUL")
+
+// errTemplateCycle is returned when adding a template would make it
+// include itself, directly or through other templates. It holds the
+// chain of includes that leads back to the template.
+type errTemplateCycle string
+
+func (tc errTemplateCycle) Error() string {
+	return "template includes itself: " + string(tc)
+}
+
 // ErrAlreadyExists is the error that is returned when you attempt to register
 // a given format string when it has already been registered.
 type errAlreadyExists string
@@ -94,3 +248,152 @@ type errUnknownEncoder string
 func (ue errUnknownEncoder) Error() string {
 	return "format string specified unknown encoder " + string(ue)
 }
+
+// A ParseError is returned when a format string can not be compiled. It
+// records where in the format string the problem was found.
+//
+// Err is the underlying cause, and is available via errors.Unwrap, so
+// errors.Is and errors.As can still be used to examine it.
+type ParseError struct {
+	// Offset is the byte offset into the format string of the problem.
+	Offset int
+	// Index is the 0-based index of the offending directive among all
+	// the directives in the format string, not counting %%; escapes.
+	Index int
+	// Directive is the raw text of the offending directive, as it
+	// appeared in the format string.
+	Directive string
+	// Snippet is the Directive with a caret on the line below it,
+	// pointing at the problem.
+	Snippet string
+	Err     error
+}
+
+// Error implements the Error interface on the ParseError.
+func (pe *ParseError) Error() string {
+	return "directive " + strconv.Itoa(pe.Index) + " (" + pe.Directive +
+		") at offset " + strconv.Itoa(pe.Offset) + ": " + pe.Err.Error()
+}
+
+// Unwrap returns the underlying cause of the ParseError.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// A DirectiveError is returned when a directive fails while a format
+// string is being interpolated, either because a formatter or encoder
+// returned an error, or because the writer they were writing to did.
+//
+// Err is the underlying cause, and is available via errors.Unwrap, so
+// errors.Is and errors.As can still be used to examine it.
+type DirectiveError struct {
+	// Offset is the byte offset into the format string of the directive.
+	Offset int
+	// Index is the 0-based index of the directive among all the
+	// directives in the format string, not counting %%; escapes.
+	Index int
+	// Directive is the raw text of the directive, as it appeared in the
+	// format string.
+	Directive string
+	Err       error
+}
+
+// Error implements the Error interface on the DirectiveError.
+func (de *DirectiveError) Error() string {
+	return "directive " + strconv.Itoa(de.Index) + " (" + de.Directive +
+		") at offset " + strconv.Itoa(de.Offset) + " failed: " + de.Err.Error()
+}
+
+// Unwrap returns the underlying cause of the DirectiveError.
+func (de *DirectiveError) Unwrap() error {
+	return de.Err
+}
+
+// caretSnippet renders text with a caret on the following line under the
+// byte at pos, for pointing out problems in directives.
+func caretSnippet(text string, pos int) string {
+	if pos > len(text) {
+		pos = len(text)
+	}
+	caret := make([]byte, 0, pos+1)
+	for _, r := range text[:pos] {
+		// one column per rune, preserving tabs so the caret still lines up
+		if r == '\t' {
+			caret = append(caret, '\t')
+		} else {
+			caret = append(caret, ' ')
+		}
+	}
+	return text + "\n" + string(append(caret, '^'))
+}
+
+// ErrMissingArguments is the error returned in strict mode when fewer
+// args are given than the format string uses.
+type ErrMissingArguments struct {
+	Needed int
+	Given  int
+}
+
+// Error implements the Error interface on ErrMissingArguments.
+func (ma ErrMissingArguments) Error() string {
+	return "format string uses " + strconv.Itoa(ma.Needed) +
+		" arguments, but only " + strconv.Itoa(ma.Given) + " were given"
+}
+
+// ErrExtraArguments is the error returned in strict mode when args are
+// given that the format string does not use.
+//
+// Indexes are the 1-based indexes of the unused args, numbered the same
+// way as explicit argument indexes in format strings, and Types are the
+// names of their corresponding types.
+type ErrExtraArguments struct {
+	Indexes []int
+	Types   []string
+}
+
+// Error implements the Error interface on ErrExtraArguments.
+func (ea ErrExtraArguments) Error() string {
+	str := "unused arguments:"
+	for idx, argIdx := range ea.Indexes {
+		if idx > 0 {
+			str += ","
+		}
+		str += " " + strconv.Itoa(argIdx)
+		if idx < len(ea.Types) {
+			str += " (" + ea.Types[idx] + ")"
+		}
+	}
+	return str
+}
+
+// checkArity checks that the nargs args exactly match the used positional
+// args. args may be nil, in which case the types of extra args can't be
+// reported.
+func checkArity(used []bool, nargs int, args []interface{}) error {
+	if len(used) > nargs {
+		return ErrMissingArguments{len(used), nargs}
+	}
+
+	var extra ErrExtraArguments
+	for idx := 0; idx < nargs; idx++ {
+		if idx < len(used) && used[idx] {
+			continue
+		}
+		extra.Indexes = append(extra.Indexes, idx+1)
+		if args != nil {
+			extra.Types = append(extra.Types, typeName(args[idx]))
+		}
+	}
+	if extra.Indexes != nil {
+		return extra
+	}
+	return nil
+}
+
+func typeName(arg interface{}) string {
+	t := reflect.TypeOf(arg)
+	if t == nil {
+		return "nil"
+	}
+	return t.String()
+}
@@ -0,0 +1,251 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// This file contains the URL encoders.
+//
+// These all work a byte at a time, since percent-encoding works on the
+// UTF-8 encoding of a rune rather than the rune itself. Every byte of a
+// multi-byte rune gets encoded, so they can never split one.
+
+var urlQuerySpec = ParamSpec{
+	{Name: "plus", Type: BoolParam},
+}
+
+var urlSpec = ParamSpec{
+	{Name: "schemes", Type: StringParam, Default: "http https mailto"},
+	{Name: "relative", Type: BoolParam, Default: "true"},
+}
+
+// URLQuery defines an Encoder for a name or value in the query string of
+// a URL, as in "?q=%urlquery;". Everything except ASCII letters, digits
+// and "-._~" is percent-encoded. With the "plus" parameter, spaces are
+// written as "+", as HTML forms do.
+func URLQuery(inner io.Writer, args []byte) (io.Writer, error) {
+	params, err := urlQuerySpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return urlQueryEncoder(inner, params)
+}
+
+func urlQueryEncoder(inner io.Writer, params Params) (io.Writer, error) {
+	if !params.Bool("plus") {
+		return percentEncoder(inner, isUnreserved), nil
+	}
+	// spaces are let through, and then replaced, which is unambiguous
+	// since + itself is encoded
+	return percentEncoder(WriterFunc(func(b []byte) (int, error) {
+		_, err := inner.Write(bytes.Replace(b, []byte(" "), []byte("+"), -1))
+		return len(b), err
+	}), func(b byte) bool {
+		return b == ' ' || isUnreserved(b)
+	}), nil
+}
+
+// URLPath defines an Encoder for a single segment of the path of a URL,
+// as in "/users/%urlpath;/profile". Slashes are percent-encoded, along
+// with everything else that can't appear in a segment, and so is a
+// segment that is entirely "." or "..", so that the path can't be made
+// to go anywhere else. It takes no parameters.
+func URLPath(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, urlPathEncoder)
+}
+
+func urlPathEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return &dotSegmentWriter{inner: percentEncoder(inner, isPathChar), raw: inner}, nil
+}
+
+// A dotSegmentWriter holds back leading dots until it knows whether they
+// are the whole segment.
+type dotSegmentWriter struct {
+	inner io.Writer
+	// the writer under the percent-encoding
+	raw  io.Writer
+	dots int
+	// set once anything but dots has been written
+	started bool
+}
+
+func (dw *dotSegmentWriter) Write(b []byte) (int, error) {
+	if dw.started {
+		_, err := dw.inner.Write(b)
+		return len(b), err
+	}
+
+	rest := b
+	for len(rest) > 0 && rest[0] == '.' && dw.dots < 3 {
+		dw.dots++
+		rest = rest[1:]
+	}
+	if len(rest) == 0 && dw.dots < 3 {
+		return len(b), nil
+	}
+	dw.started = true
+	_, err := dw.inner.Write(append(bytes.Repeat([]byte("."), dw.dots), rest...))
+	return len(b), err
+}
+
+// Close writes out the dots of a segment that was nothing but dots.
+func (dw *dotSegmentWriter) Close() error {
+	if dw.started || dw.dots == 0 {
+		return nil
+	}
+	_, err := dw.raw.Write(bytes.Repeat([]byte("%2E"), dw.dots))
+	return err
+}
+
+// URLFragment defines an Encoder for the fragment of a URL, after the
+// "#". Slashes and question marks are allowed, as they are in fragments,
+// but anything else that is not allowed is percent-encoded. It takes no
+// parameters.
+func URLFragment(inner io.Writer, args []byte) (io.Writer, error) {
+	return encodeNoParams(inner, args, urlFragmentEncoder)
+}
+
+func urlFragmentEncoder(inner io.Writer, _ Params) (io.Writer, error) {
+	return percentEncoder(inner, func(b byte) bool {
+		return isPathChar(b) || b == '/' || b == '?'
+	}), nil
+}
+
+// URL defines an Encoder for a whole URL, as in "<a href=%url|htmlattr;>".
+// URLs with a scheme are only allowed if it is one of the "schemes" given
+// as a space-separated list, "http https mailto" by default, so that
+// "javascript:" and "data:" URLs are rejected with an error before
+// anything is written. URLs without a scheme are allowed unless
+// "relative=false" is given.
+//
+// The URL is otherwise passed through, except that anything that may not
+// appear in a URL at all, such as spaces, quotes and non-ASCII
+// characters, is percent-encoded. The URL still needs encoding for where
+// it is used, such as by htmlattr.
+func URL(inner io.Writer, args []byte) (io.Writer, error) {
+	params, err := urlSpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return urlEncoder(inner, params)
+}
+
+func urlEncoder(inner io.Writer, params Params) (io.Writer, error) {
+	schemes := map[string]bool{}
+	for _, scheme := range strings.Fields(params.String("schemes")) {
+		schemes[strings.ToLower(scheme)] = true
+	}
+	return &urlWriter{
+		inner:    percentEncoder(inner, isURLChar),
+		schemes:  schemes,
+		relative: params.Bool("relative"),
+	}, nil
+}
+
+// A urlWriter holds back the start of the URL until it knows the scheme.
+type urlWriter struct {
+	inner    io.Writer
+	schemes  map[string]bool
+	relative bool
+	held     []byte
+	// set once the scheme has been checked
+	checked bool
+}
+
+func (uw *urlWriter) Write(b []byte) (int, error) {
+	if uw.checked {
+		_, err := uw.inner.Write(b)
+		return len(b), err
+	}
+
+	uw.held = append(uw.held, b...)
+	end := bytes.IndexAny(uw.held, ":/?#")
+	if end == -1 {
+		return len(b), nil
+	}
+	err := uw.check(end)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// check checks the scheme, which ends at end, if there is one, and then
+// writes out what has been held back.
+func (uw *urlWriter) check(end int) error {
+	uw.checked = true
+	if end >= 0 && uw.held[end] == ':' {
+		scheme := string(uw.held[:end])
+		if !uw.schemes[strings.ToLower(scheme)] {
+			return errDisallowedScheme(scheme)
+		}
+	} else if !uw.relative {
+		return errRelativeURL
+	}
+
+	_, err := uw.inner.Write(uw.held)
+	uw.held = nil
+	return err
+}
+
+// Close checks and writes a URL that was too short to know the scheme of
+// until it ended.
+func (uw *urlWriter) Close() error {
+	if uw.checked {
+		return nil
+	}
+	return uw.check(-1)
+}
+
+// percentEncoder returns a writer that percent-encodes every byte written
+// to it except the ones keep returns true for.
+func percentEncoder(inner io.Writer, keep func(byte) bool) io.Writer {
+	return WriterFunc(func(b []byte) (int, error) {
+		goodfrom := 0
+		for idx, c := range b {
+			if keep(c) {
+				continue
+			}
+			_, err := inner.Write(b[goodfrom:idx])
+			if err != nil {
+				return 0, err
+			}
+			_, err = inner.Write([]byte{'%', upperHex[c>>4], upperHex[c&15]})
+			if err != nil {
+				return 0, err
+			}
+			goodfrom = idx + 1
+		}
+		if goodfrom < len(b) {
+			_, err := inner.Write(b[goodfrom:])
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	})
+}
+
+const upperHex = "0123456789ABCDEF"
+
+// isUnreserved returns whether the byte is an unreserved character of RFC
+// 3986, which never needs encoding anywhere in a URL.
+func isUnreserved(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') ||
+		(b >= '0' && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~'
+}
+
+// isPathChar returns whether the byte can appear in a path segment.
+// Single quotes are left out, so that the result can't end a quoted
+// attribute or string, even if it is used without encoding it further.
+func isPathChar(b byte) bool {
+	return isUnreserved(b) || strings.IndexByte("!$&()*+,;=:@", b) != -1
+}
+
+// isURLChar returns whether the byte can appear in a URL at all, with the
+// same exception.
+func isURLChar(b byte) bool {
+	return isPathChar(b) || strings.IndexByte("/?#[]%", b) != -1
+}
@@ -0,0 +1,115 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestURLEncoders(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		format   string
+		arg      string
+		expected string
+	}{
+		{"%urlquery;", "a b&c=d/é", "a%20b%26c%3Dd%2F%C3%A9"},
+		{"%urlquery;", "-._~AZaz09", "-._~AZaz09"},
+		{"%urlquery:plus;", "a b+c", "a+b%2Bc"},
+		{"%urlquery;", "\xff%", "%FF%25"},
+
+		{"/%urlpath;/", "a/b", "/a%2Fb/"},
+		{"/%urlpath;/", "a b?c#d", "/a%20b%3Fc%23d/"},
+		{"/%urlpath;/", "x:y@z;a=1,2", "/x:y@z;a=1,2/"},
+		{"/%urlpath;/", "é'\"<", "/%C3%A9%27%22%3C/"},
+		{"/%urlpath;/", ".", "/%2E/"},
+		{"/%urlpath;/", "..", "/%2E%2E/"},
+		{"/%urlpath;/", "...", "/.../"},
+		{"/%urlpath;/", "..a", "/..a/"},
+		{"/%urlpath;/", ".a.", "/.a./"},
+		{"/%urlpath;/", "", "//"},
+
+		{"#%urlfragment;", "a/b?c#d e", "#a/b?c%23d%20e"},
+
+		{"%url;", "https://example.com/a b?q=\"x\"#f", "https://example.com/a%20b?q=%22x%22#f"},
+		{"%url;", "HTTP://example.com/", "HTTP://example.com/"},
+		{"%url;", "mailto:me@example.com", "mailto:me@example.com"},
+		{"%url;", "/relative/path?x=%20", "/relative/path?x=%20"},
+		{"%url;", "page.html", "page.html"},
+		{"%url;", "", ""},
+		{"%url;", "'onclick='", "%27onclick=%27"},
+		{"%url:schemes=ftp;", "ftp://example.com/", "ftp://example.com/"},
+		{"%url:schemes=ftp HTTPS;", "https://example.com/", "https://example.com/"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr(test.format, test.arg)
+		if err != nil || res != test.expected {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %q, got %q, %v",
+				test.format, test.arg, test.expected, res, err))
+		}
+	}
+
+	// the output must mean what was put in
+	for _, s := range []string{"a b+c&d=e", "日本/語?#%", "\x00\xff"} {
+		for _, format := range []string{"%urlquery;", "%urlquery:plus;"} {
+			res, _ := i.InterpStr(format, s)
+			decoded, err := url.QueryUnescape(res)
+			if err != nil || decoded != s {
+				t.Fatal(fmt.Sprintf("for %q, %s does not round trip: %q", s, format, res))
+			}
+		}
+		res, _ := i.InterpStr("%urlpath;", s)
+		decoded, err := url.PathUnescape(res)
+		if err != nil || decoded != s {
+			t.Fatal(fmt.Sprintf("for %q, urlpath does not round trip: %q", s, res))
+		}
+	}
+
+	for format, cause := range map[string]error{
+		"%url;":                      errDisallowedScheme("javascript"),
+		"%url:schemes=http;":         errDisallowedScheme("javascript"),
+		"%url:relative=false;":       errDisallowedScheme("javascript"),
+		"%url:schemes=javascript x;": nil,
+	} {
+		w := &recordingWriter{}
+		err := i.InterpWriter(w, []byte(format), "javascript:alert(1)")
+		if cause == nil {
+			if err != nil {
+				t.Fatal(fmt.Sprintf("for %s, got unexpected error %v", format, err))
+			}
+			continue
+		}
+		if !errors.Is(err, cause) || w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, expected %v, got %q, %v", format, cause, w.String(), err))
+		}
+	}
+	for arg, cause := range map[string]error{
+		"data:text/html,<script>": errDisallowedScheme("data"),
+		"JavaScript:alert(1)":     errDisallowedScheme("JavaScript"),
+		" javascript:alert(1)":    errDisallowedScheme(" javascript"),
+		"java\tscript:alert(1)":   errDisallowedScheme("java\tscript"),
+		"vbscript:x":              errDisallowedScheme("vbscript"),
+		":x":                      errDisallowedScheme(""),
+	} {
+		_, err := i.InterpStr("%url;", arg)
+		if !errors.Is(err, cause) {
+			t.Fatal(fmt.Sprintf("for %q, expected %v, got %v", arg, cause, err))
+		}
+	}
+	_, err := i.InterpStr("%url:relative=false;", "/path")
+	if !errors.Is(err, errRelativeURL) {
+		t.Fatal("relative URLs can't be disallowed:", err)
+	}
+
+	// the scheme is found however the URL is written
+	w := &recordingWriter{}
+	enc, _ := URL(w, nil)
+	for _, chunk := range []string{"java", "scr", "ipt", ":alert(1)"} {
+		_, err = enc.Write([]byte(chunk))
+	}
+	if !errors.Is(err, errDisallowedScheme("javascript")) || w.Len() != 0 {
+		t.Fatal("the url encoder can be fooled by splitting the scheme:", err)
+	}
+}
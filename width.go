@@ -0,0 +1,371 @@
+package strinterp
+
+import (
+	"bytes"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file contains the encoders that lay text out in fixed-width
+// columns, measuring it the way a terminal displays it.
+
+var padSpec = ParamSpec{
+	{Name: "align", Type: EnumParam, Values: []string{"left", "center", "right"}, Default: "left"},
+	{Name: "width", Type: IntParam, Positional: true},
+	{Name: "fill", Type: StringParam, Default: " "},
+	{Name: "runes", Type: BoolParam},
+}
+
+var truncSpec = ParamSpec{
+	{Name: "width", Type: IntParam, Positional: true},
+	{Name: "ellipsis", Type: StringParam},
+	{Name: "runes", Type: BoolParam},
+}
+
+// Pad defines an Encoder that pads its output out to a given width, as in
+// "%RAW|pad:right,20;".
+//
+// Width is measured in display columns, where East Asian wide characters
+// take up two columns and combining marks none, or in runes with the
+// "runes" parameter. The output is aligned "left", which is the default,
+// "center" or "right", and padded with spaces, or with the single
+// character given as "fill=". Output that is already wide enough is
+// passed through untouched.
+//
+// Left-aligned output is passed straight through. Otherwise, only as much
+// of it as is needed to know whether it is wide enough is buffered.
+func Pad(w io.Writer, args []byte) (io.Writer, error) {
+	params, err := padSpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return padEncoder(w, params)
+}
+
+// Trunc defines an Encoder that truncates its output to a given width, as
+// in "%RAW|trunc:30,ellipsis=…;". If the output has to be truncated, the
+// ellipsis, if any, replaces its end, so that the result still fits in
+// the width. The width must be positive, and the ellipsis can be no wider
+// than it.
+//
+// Width is measured as in Pad. Output is never cut in the middle of a
+// rune, and is buffered only when it is within the width of the ellipsis
+// of the limit.
+func Trunc(w io.Writer, args []byte) (io.Writer, error) {
+	params, err := truncSpec.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return truncEncoder(w, params)
+}
+
+// measureFor returns how the runes parameter says to measure width.
+func measureFor(params Params) func(rune) int {
+	if params.Bool("runes") {
+		return runeWidth
+	}
+	return displayWidth
+}
+
+// checkPad checks the parameters of pad that its ParamSpec can't.
+func checkPad(params Params) error {
+	if params.Int("width") < 0 {
+		return ErrUnknownArguments{params.Raw(), "width can not be negative"}
+	}
+	fill := []byte(params.String("fill"))
+	r, size := utf8.DecodeRune(fill)
+	if len(fill) == 0 || size != len(fill) || measureFor(params)(r) != 1 {
+		return ErrUnknownArguments{params.Raw(),
+			"fill must be a single character, one column wide"}
+	}
+	return nil
+}
+
+func padEncoder(w io.Writer, params Params) (io.Writer, error) {
+	err := checkPad(params)
+	if err != nil {
+		return nil, err
+	}
+	return &padWriter{
+		inner:   w,
+		align:   params.String("align"),
+		width:   params.Int("width"),
+		fill:    []byte(params.String("fill")),
+		measure: measureFor(params),
+	}, nil
+}
+
+type padWriter struct {
+	inner   io.Writer
+	align   string
+	width   int
+	fill    []byte
+	measure func(rune) int
+	// the width of everything written so far
+	written int
+	// the output held back until it is known how much to pad it
+	held []byte
+	// set once the output is known to need no padding
+	wide bool
+}
+
+func (pw *padWriter) Write(b []byte) (int, error) {
+	pw.written += columns(b, pw.measure)
+	if pw.align == "left" || pw.wide {
+		_, err := pw.inner.Write(b)
+		return len(b), err
+	}
+
+	pw.held = append(pw.held, b...)
+	if pw.written >= pw.width {
+		pw.wide = true
+		_, err := pw.inner.Write(pw.held)
+		pw.held = nil
+		return len(b), err
+	}
+	return len(b), nil
+}
+
+// Close writes out the padding, along with anything held back.
+func (pw *padWriter) Close() error {
+	missing := pw.width - pw.written
+	if missing < 0 {
+		missing = 0
+	}
+	before := 0
+	switch pw.align {
+	case "right":
+		before = missing
+	case "center":
+		before = missing / 2
+	}
+
+	padding := bytes.Repeat(pw.fill, before)
+	padding = append(padding, pw.held...)
+	padding = append(padding, bytes.Repeat(pw.fill, missing-before)...)
+	pw.held = nil
+	if len(padding) == 0 {
+		return nil
+	}
+	_, err := pw.inner.Write(padding)
+	return err
+}
+
+// checkTrunc checks the parameters of trunc that its ParamSpec can't.
+func checkTrunc(params Params) error {
+	if params.Int("width") <= 0 {
+		return ErrUnknownArguments{params.Raw(), "width must be positive"}
+	}
+	if columns([]byte(params.String("ellipsis")), measureFor(params)) > params.Int("width") {
+		return ErrUnknownArguments{params.Raw(), "ellipsis must fit in the width"}
+	}
+	return nil
+}
+
+func truncEncoder(w io.Writer, params Params) (io.Writer, error) {
+	err := checkTrunc(params)
+	if err != nil {
+		return nil, err
+	}
+	measure := measureFor(params)
+	ellipsis := []byte(params.String("ellipsis"))
+	return &truncWriter{
+		inner:    w,
+		width:    params.Int("width"),
+		ellipsis: ellipsis,
+		fits:     params.Int("width") - columns(ellipsis, measure),
+		measure:  measure,
+	}, nil
+}
+
+type truncWriter struct {
+	inner    io.Writer
+	width    int
+	ellipsis []byte
+	// how wide the output can get and still have room for the ellipsis
+	fits    int
+	measure func(rune) int
+	// the width of everything written so far
+	written int
+	// the output past fits, held back until it is known whether the
+	// ellipsis replaces it
+	held []byte
+	// set once the output has been truncated
+	cut bool
+}
+
+func (tw *truncWriter) Write(b []byte) (int, error) {
+	if tw.cut {
+		return len(b), nil
+	}
+
+	// the end of the part of b that fits with room for the ellipsis
+	passed := 0
+	for idx := 0; idx < len(b); {
+		r, size := utf8.DecodeRune(b[idx:])
+		width := tw.measure(r)
+		switch {
+		case tw.written+width <= tw.fits:
+			passed = idx + size
+		case tw.written+width <= tw.width:
+			tw.held = append(tw.held, b[idx:idx+size]...)
+		default:
+			tw.cut = true
+			tw.held = nil
+			_, err := tw.inner.Write(append(b[:passed:passed], tw.ellipsis...))
+			return len(b), err
+		}
+		tw.written += width
+		idx += size
+	}
+
+	if passed == 0 {
+		return len(b), nil
+	}
+	_, err := tw.inner.Write(b[:passed])
+	return len(b), err
+}
+
+// Close writes out the held back output, which turned out to fit.
+func (tw *truncWriter) Close() error {
+	if tw.cut || len(tw.held) == 0 {
+		return nil
+	}
+	_, err := tw.inner.Write(tw.held)
+	tw.held = nil
+	return err
+}
+
+// columns returns the width of b, according to measure.
+func columns(b []byte, measure func(rune) int) int {
+	width := 0
+	for idx := 0; idx < len(b); {
+		r, size := utf8.DecodeRune(b[idx:])
+		width += measure(r)
+		idx += size
+	}
+	return width
+}
+
+func runeWidth(rune) int {
+	return 1
+}
+
+// displayWidth returns the number of columns a terminal uses to display
+// the rune. This follows the usual wcwidth conventions: control
+// characters, combining marks and other zero-width characters take up
+// none, East Asian wide and fullwidth characters take up two, and
+// everything else, including ambiguous characters, takes up one.
+func displayWidth(r rune) int {
+	if r < ' ' || (r >= 0x7f && r < 0xa0) {
+		return 0
+	}
+	if r < 0x300 {
+		return 1
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) ||
+		(r >= 0x1160 && r <= 0x11ff) {
+		return 0
+	}
+	if unicode.Is(wideRunes, r) {
+		return 2
+	}
+	return 1
+}
+
+// wideRunes are the East Asian Wide and Fullwidth ranges of Unicode, along
+// with the emoji that are displayed wide.
+var wideRunes = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x231a, 0x231b, 1},
+		{0x2329, 0x232a, 1},
+		{0x23e9, 0x23ec, 1},
+		{0x23f0, 0x23f0, 1},
+		{0x23f3, 0x23f3, 1},
+		{0x25fd, 0x25fe, 1},
+		{0x2614, 0x2615, 1},
+		{0x2648, 0x2653, 1},
+		{0x267f, 0x267f, 1},
+		{0x2693, 0x2693, 1},
+		{0x26a1, 0x26a1, 1},
+		{0x26aa, 0x26ab, 1},
+		{0x26bd, 0x26be, 1},
+		{0x26c4, 0x26c5, 1},
+		{0x26ce, 0x26ce, 1},
+		{0x26d4, 0x26d4, 1},
+		{0x26ea, 0x26ea, 1},
+		{0x26f2, 0x26f3, 1},
+		{0x26f5, 0x26f5, 1},
+		{0x26fa, 0x26fa, 1},
+		{0x26fd, 0x26fd, 1},
+		{0x2705, 0x2705, 1},
+		{0x270a, 0x270b, 1},
+		{0x2728, 0x2728, 1},
+		{0x274c, 0x274c, 1},
+		{0x274e, 0x274e, 1},
+		{0x2753, 0x2755, 1},
+		{0x2757, 0x2757, 1},
+		{0x2795, 0x2797, 1},
+		{0x27b0, 0x27b0, 1},
+		{0x27bf, 0x27bf, 1},
+		{0x2b1b, 0x2b1c, 1},
+		{0x2b50, 0x2b50, 1},
+		{0x2b55, 0x2b55, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x16fe0, 0x16fe4, 1},
+		{0x17000, 0x18cff, 1},
+		{0x1b000, 0x1b2ff, 1},
+		{0x1f004, 0x1f004, 1},
+		{0x1f0cf, 0x1f0cf, 1},
+		{0x1f18e, 0x1f18e, 1},
+		{0x1f191, 0x1f19a, 1},
+		{0x1f200, 0x1f251, 1},
+		{0x1f260, 0x1f265, 1},
+		{0x1f300, 0x1f320, 1},
+		{0x1f32d, 0x1f335, 1},
+		{0x1f337, 0x1f37c, 1},
+		{0x1f37e, 0x1f393, 1},
+		{0x1f3a0, 0x1f3ca, 1},
+		{0x1f3cf, 0x1f3d3, 1},
+		{0x1f3e0, 0x1f3f0, 1},
+		{0x1f3f4, 0x1f3f4, 1},
+		{0x1f3f8, 0x1f43e, 1},
+		{0x1f440, 0x1f440, 1},
+		{0x1f442, 0x1f4fc, 1},
+		{0x1f4ff, 0x1f53d, 1},
+		{0x1f54b, 0x1f54e, 1},
+		{0x1f550, 0x1f567, 1},
+		{0x1f57a, 0x1f57a, 1},
+		{0x1f595, 0x1f596, 1},
+		{0x1f5a4, 0x1f5a4, 1},
+		{0x1f5fb, 0x1f64f, 1},
+		{0x1f680, 0x1f6c5, 1},
+		{0x1f6cc, 0x1f6cc, 1},
+		{0x1f6d0, 0x1f6d2, 1},
+		{0x1f6d5, 0x1f6d7, 1},
+		{0x1f6eb, 0x1f6ec, 1},
+		{0x1f6f4, 0x1f6fc, 1},
+		{0x1f7e0, 0x1f7eb, 1},
+		{0x1f90c, 0x1f93a, 1},
+		{0x1f93c, 0x1f945, 1},
+		{0x1f947, 0x1f9ff, 1},
+		{0x1fa70, 0x1faff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
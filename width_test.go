@@ -0,0 +1,150 @@
+package strinterp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPad(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		params   string
+		arg      string
+		expected string
+	}{
+		{"5", "ab", "ab   "},
+		{"left,5", "ab", "ab   "},
+		{"right,5", "ab", "   ab"},
+		{"center,5", "ab", " ab  "},
+		{"center,6,fill=.", "ab", "..ab.."},
+		{"width=3,align=right,fill=·", "a", "··a"},
+		{"right,2", "abc", "abc"},
+		{"right,3", "abc", "abc"},
+		{"", "abc", "abc"},
+		{"right,5", "日本", " 日本"},
+		{"right,5,runes", "日本", "   日本"},
+		{"right,4", "é", "   é"},
+		{"center,4", "", "    "},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr("[%RAW|pad:"+test.params+";]", test.arg)
+		if err != nil || res != "["+test.expected+"]" {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %q, got %q, %v",
+				test.params, test.arg, test.expected, res, err))
+		}
+	}
+
+	// the output is only buffered until it is wide enough
+	w := &recordingWriter{}
+	pad, _ := Pad(w, []byte("right,4"))
+	for _, chunk := range []string{"ab", "cd", "ef"} {
+		_, _ = pad.Write([]byte(chunk))
+		if chunk == "ab" && w.Len() != 0 {
+			t.Fatal("pad does not wait to see if it needs padding")
+		}
+	}
+	if w.String() != "abcdef" {
+		t.Fatal("pad buffers too much:", w.String())
+	}
+
+	for _, params := range []string{"fill=", "fill=ab", "fill=日", "middle", "-1"} {
+		_, err := Pad(w, []byte(params))
+		var ua ErrUnknownArguments
+		if !errors.As(err, &ua) {
+			t.Fatal(fmt.Sprintf("for %s, expected bad arguments, got %v", params, err))
+		}
+	}
+}
+
+func TestTrunc(t *testing.T) {
+	i := NewDefaultInterpolator()
+	tests := []struct {
+		params   string
+		arg      string
+		expected string
+	}{
+		{"5", "abcdefg", "abcde"},
+		{"5", "abcde", "abcde"},
+		{"5", "abc", "abc"},
+		{"5,ellipsis=…", "abcdefg", "abcd…"},
+		{"5,ellipsis=…", "abcde", "abcde"},
+		{"5,ellipsis=...", "abcdef", "ab..."},
+		{"width=3", "日本語", "日"},
+		{"4", "日本語", "日本"},
+		{"4,runes", "日本語です", "日本語で"},
+		{"3,ellipsis=…", "日本語", "日…"},
+		{"2", "ééé", "éé"},
+	}
+
+	for _, test := range tests {
+		res, err := i.InterpStr("[%RAW|trunc:"+test.params+";]", test.arg)
+		if err != nil || res != "["+test.expected+"]" {
+			t.Fatal(fmt.Sprintf("for %s with %q, expected %q, got %q, %v",
+				test.params, test.arg, test.expected, res, err))
+		}
+	}
+
+	// output is only held back near the limit, and is never cut
+	// mid-rune, however it is written
+	w := &recordingWriter{}
+	trunc, _ := Trunc(w, []byte("6,ellipsis=.."))
+	for _, chunk := range []string{"ab", "cd", "e", "日", "f"} {
+		_, _ = trunc.Write([]byte(chunk))
+		if chunk == "cd" && w.String() != "abcd" {
+			t.Fatal("trunc holds back too much:", w.String())
+		}
+	}
+	if w.String() != "abcd.." {
+		t.Fatal("trunc fails across writes:", w.String())
+	}
+
+	res, err := i.InterpStr("%RAW|trunc:5|pad:right,7,fill=.;", "abcdefg")
+	if err != nil || res != "..abcde" {
+		t.Fatal("trunc and pad do not combine:", res, err)
+	}
+
+	for _, params := range []string{"", "0", "-1", "x", "1,ellipsis=...", "2,ellipsis=日日",
+		"2,runes,ellipsis=日日日"} {
+		_, err := Trunc(w, []byte(params))
+		var ua ErrUnknownArguments
+		if !errors.As(err, &ua) {
+			t.Fatal(fmt.Sprintf("for %s, expected bad arguments, got %v", params, err))
+		}
+	}
+}
+
+func TestWidthValidation(t *testing.T) {
+	i := NewDefaultInterpolator()
+	for _, format := range []string{
+		"hello %RAW|pad:-1;",
+		"hello %RAW|pad:5,fill=ab;",
+		"hello %RAW|pad:5,fill=日;",
+		"hello %RAW|trunc:0;",
+		"hello %RAW|trunc:-3;",
+		"hello %RAW|trunc:1,ellipsis=...;",
+		"hello %RAW|trunc:2,ellipsis=日日;",
+	} {
+		err := i.Validate(format)
+		var pe *ParseError
+		var ua ErrUnknownArguments
+		if !errors.As(err, &pe) || !errors.As(err, &ua) {
+			t.Fatal(fmt.Sprintf("for %s, expected a parse error, got %v", format, err))
+		}
+
+		w := &recordingWriter{}
+		err = i.InterpWriter(w, []byte(format), "world")
+		if err == nil || w.Len() != 0 {
+			t.Fatal(fmt.Sprintf("for %s, %q was written before the error %v", format, w.String(), err))
+		}
+	}
+
+	for _, format := range []string{"%RAW|pad:0;", "%RAW|trunc:3,ellipsis=...;",
+		"%RAW|trunc:2,runes,ellipsis=日日;"} {
+		err := i.Validate(format)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("for %s, got unexpected error %v", format, err))
+		}
+	}
+}